@@ -3,21 +3,83 @@ package main
 import (
   "errors"
   "fmt"
+  "image"
   "log"
   "log/slog"
   "machine"
+  "net"
+  "net/netip"
   "orangeclock/pkg/epd2in9v2"
   "orangeclock/pkg/http"
+  "orangeclock/pkg/ota"
+  "orangeclock/pkg/syslog"
+  "orangeclock/pkg/telemetry"
+  "orangeclock/pkg/widget"
+  "orangeclock/pkg/wifi"
+  "strconv"
   "strings"
   "time"
 )
 
+// Partial refreshes leave ghosts, the display does a full refresh once any of
+// these is reached, see epd2in9v2.GhostingPolicy.
 const displayFullReloadInterval = 20 * time.Hour
+const displayMaxPartials = 50
+const displayMaxChangedPixels = 20_000
+const displayFullReloadAt = 3 * time.Hour // time of day
 const requestDataInterval = 10 * time.Minute
 const targetDataServerAddr = "10.10.10.12:48080"
 const targetRequestPath = "/mempool/api/orangeclock"
 const targetDatetimeRequestPath = "/datetime"
 
+// Logs are sent to serial and, once the network is up, to this syslog server.
+const syslogServerAddr = "10.10.10.12:514"
+
+// In bitmap mode the server renders the whole screen and the clock only shows
+// the image, see epd2in9v2.Device.LoadBitmap for the accepted formats.
+const bitmapMode = false
+const targetBitmapRequestPath = "/orangeclock/bitmap"
+const bitmapBufferSize = 4736 + 1024 // image plus HTTP and PBM headers
+
+// Firmware updates are offered by the server through a manifest, see package ota.
+const otaManifestPath = "/orangeclock/firmware/manifest"
+const otaCheckInterval = 24 * time.Hour
+
+// Health reports are posted to the server, see telemetry.Report.
+const telemetryPath = "/orangeclock/telemetry"
+const telemetryInterval = 10 * time.Minute
+
+// The price chart shows the samples of the last 24 hours, one per update,
+// unless the server sends a "HISTORY" line with its own samples.
+const priceHistorySize = int(24 * time.Hour / requestDataInterval)
+
+var priceChartBounds = image.Rect(210, 28, 294, 66)
+var priceHistory = widget.NewSeries(priceHistorySize)
+
+// The fee chart shows the projected mempool blocks of a "MEMPOOL" line, or the
+// fees of the text. Its three bars are 20 pixels wide, enough for labels of
+// three characters.
+var feeChartBounds = image.Rect(228, 72, 294, 128)
+var feeLabels = []string{"LO", "MID", "HI"}
+
+// The halving progress of the text is shown as a bar below it, split with the
+// difficulty epoch of a "DIFFICULTY" line like "DIFFICULTY 1234/2016".
+var progressBounds = image.Rect(9, 91, 224, 98)
+
+// The diagnostics page replaces the data once this many retries are used up.
+const diagnosticsAfterFailures = 3
+
+// run restarts after this many display faults in a row, which initializes the
+// display again.
+const maxDisplayFailures = 3
+
+// stats outlives restarts of run, so the reports cover the whole uptime.
+var stats telemetry.Stats
+var bootTime = time.Now()
+
+// firmwareVersion is set at build time with -ldflags="-X main.firmwareVersion=1.2.0"
+var firmwareVersion = "dev"
+
 func main() {
   for {
     if err := run(); err != nil {
@@ -29,17 +91,73 @@ func main() {
 
 func run() error {
   time.Sleep(1 * time.Second)
-  logger := slog.New(slog.NewTextHandler(machine.Serial, &slog.HandlerOptions{
+  serialLog := slog.NewTextHandler(machine.Serial, &slog.HandlerOptions{
     Level: slog.LevelWarn,
-  }))
+  })
+  remoteLog := syslog.NewHandler(syslog.Config{
+    Server:   netip.MustParseAddrPort(syslogServerAddr),
+    Hostname: "pico-orangeclock",
+    AppName:  "orangeclock",
+    Level:    slog.LevelWarn,
+  })
+  defer remoteLog.Close()
+  logger := slog.New(syslog.NewMultiHandler(serialLog, remoteLog))
   logger.Debug("starting..")
 
-  display := epd2in9v2.NewPaperDisplay(logger)
+  displayFailures := 0
+  // checkDisplay logs and counts display faults. It returns an error once the
+  // display failed too often in a row, other errors are left to the caller.
+  checkDisplay := func(err error) error {
+    if err == nil {
+      displayFailures = 0
+      return nil
+    }
+    if !errors.Is(err, epd2in9v2.ErrDisplay) {
+      return nil
+    }
+    logger.Error("display", slog.String("err", err.Error()))
+    stats.Error(err)
+    displayFailures++
+    if displayFailures >= maxDisplayFailures {
+      return fmt.Errorf("display failed %d times, restarting: %w", displayFailures, err)
+    }
+    return nil
+  }
+
+  display, err := epd2in9v2.NewPaperDisplay(logger)
+  // The next run creates a new display, its counts start at zero.
+  defer func() {
+    stats.AddRefreshes(display.Display.RefreshCounts())
+  }()
+  if err := checkDisplay(err); err != nil {
+    return err
+  }
   httpClient, ssid, err := http.NewHttpClient(logger, targetDataServerAddr)
   if err != nil {
     return err
   }
   logger.Debug("connected to", slog.String("ssid", ssid))
+  remoteLog.Attach(httpClient.Stack(), httpClient.DHCP().Router())
+
+  heartbeat := telemetry.Heartbeat{
+    Client:  httpClient,
+    Path:    telemetryPath,
+    Version: firmwareVersion,
+    Display: &display.Display,
+    Stats:   &stats,
+    Boot:    bootTime,
+  }
+  heartbeat.SetDeviceID(httpClient.Stack().HardwareAddr6())
+
+  updater, err := ota.New(ota.Config{
+    Client:         httpClient,
+    ManifestPath:   otaManifestPath,
+    CurrentVersion: firmwareVersion,
+    Logger:         logger,
+  })
+  if err != nil {
+    logger.Error("ota disabled", slog.String("err", err.Error()))
+  }
 
   cTimeString, err := httpClient.NewRequest(targetDatetimeRequestPath)
   if err != nil {
@@ -52,46 +170,170 @@ func run() error {
   }
 
   // Start
-  t := time.Now().Add(displayFullReloadInterval)
-  display.UpdateWlanStatus(fmt.Sprintf("#%s", strings.ToUpper(ssid)))
-  retryCount := 5
+  runStart := time.Now()
+  display.SetRefreshPolicy(&epd2in9v2.GhostingPolicy{
+    MaxPartials:      displayMaxPartials,
+    MaxChangedPixels: displayMaxChangedPixels,
+    MaxAge:           displayFullReloadInterval,
+    FullAt:           []time.Duration{displayFullReloadAt},
+    Clock: func() time.Time {
+      return startTime.Add(time.Since(runStart))
+    },
+  })
+  err = display.UpdateWlanStatus(fmt.Sprintf("#%s", strings.ToUpper(ssid)))
+  if err := checkDisplay(err); err != nil {
+    return err
+  }
+  otaCheck := time.Now().Add(otaCheckInterval)
+  var nextHeartbeat time.Time
+  const maxRetries = 5
+  retryCount := maxRetries
+  var bitmapBuf []byte
+  if bitmapMode {
+    bitmapBuf = make([]byte, bitmapBufferSize)
+  }
   for {
     logger.Warn("run update cycle")
-    if time.Now().After(t) {
-      logger.Warn("do a full display reload")
-      display.ClearAndSleep()
-      t = time.Now().Add(displayFullReloadInterval)
+    retriesBefore := retryCount
+
+    if updater != nil && time.Now().After(otaCheck) {
+      checkForUpdate(logger, updater)
+      otaCheck = time.Now().Add(otaCheckInterval)
+    }
+
+    if time.Now().After(nextHeartbeat) {
+      if err := heartbeat.Send(); err != nil {
+        logger.Error("telemetry", slog.String("err", err.Error()))
+      }
+      nextHeartbeat = time.Now().Add(telemetryInterval)
+    }
+
+    if bitmapMode {
+      err = updateBitmap(display, httpClient, bitmapBuf)
+      if err := checkDisplay(err); err != nil {
+        return err
+      }
+      // Display faults are counted by checkDisplay, the data was fetched.
+      if err != nil && !errors.Is(err, epd2in9v2.ErrDisplay) {
+        logger.Error("error while updating bitmap", slog.String("err", err.Error()))
+        stats.FetchFailed(err)
+        retryCount--
+      } else {
+        stats.FetchSucceeded()
+      }
+      if retryCount <= 0 {
+        return errors.New("failed updating bitmap, retries exhausted, restarting")
+      }
+      if err != nil && maxRetries-retryCount >= diagnosticsAfterFailures {
+        err := display.ShowDiagnostics(diagnosticsLines(httpClient, display, ssid))
+        if err := checkDisplay(err); err != nil {
+          return err
+        }
+      }
+      time.Sleep(requestDataInterval)
+      continue
     }
 
     res, err := httpClient.NewRequest(targetRequestPath)
     if err != nil {
       logger.Error("error while request", slog.String("err", err.Error()))
+      stats.FetchFailed(err)
       retryCount--
+    } else {
+      stats.FetchSucceeded()
     }
     if retryCount <= 0 {
       return errors.New("failed requesting, retries exhausted, restarting")
     }
     logger.Debug("response to display", slog.String("content", res))
     err = drawLines(display, res, startTime)
-    if err != nil {
+    if err := checkDisplay(err); err != nil {
+      return err
+    }
+    if err != nil && !errors.Is(err, epd2in9v2.ErrDisplay) {
       logger.Error(err.Error())
+      stats.Error(err)
       retryCount--
     }
     if retryCount <= 0 {
       return errors.New("failed drawing, retries exhausted, restarting")
     }
+    if retryCount < retriesBefore && maxRetries-retryCount >= diagnosticsAfterFailures {
+      err := display.ShowDiagnostics(diagnosticsLines(httpClient, display, ssid))
+      if err := checkDisplay(err); err != nil {
+        return err
+      }
+    }
     time.Sleep(requestDataInterval)
   }
+}
 
-  return nil
+func diagnosticsLines(c *http.HttpClient, d *epd2in9v2.PaperDisplay, ssid string) []string {
+  diag := c.Diagnostics()
+  lastErr := diag.LastError
+  if lastErr == "" {
+    lastErr = "-"
+  }
+  var nicStats wifi.NICStats
+  if nic := wifi.ActiveNIC(); nic != nil {
+    nicStats = nic.Stats()
+  }
+  return []string{
+    "SSID: " + ssid,
+    "IP: " + diag.IP.String(),
+    "GATEWAY: " + diag.Gateway.String(),
+    "DNS: " + diag.DNS.String(),
+    "LEASE: " + diag.LeaseRemaining.Round(time.Minute).String(),
+    "ROUTER: " + net.HardwareAddr(diag.RouterHW[:]).String(),
+    "RSSI: N/A",
+    "SERVER: " + diag.Server.String(),
+    fmt.Sprintf("NIC RX:%d TX:%d DROP:%d ERR:%d", nicStats.RxPackets, nicStats.TxPackets, nicStats.Drops, nicStats.PollErrors),
+    "ERR: " + lastErr,
+    fmt.Sprintf("TEMP: %dC", d.Display.Temperature()/1000),
+  }
 }
 
-func drawLines(d *epd2in9v2.PaperDisplay, text string, startTime time.Time) error {
+// checkForUpdate logs a newer firmware offered by the server, installing it
+// needs a second stage bootloader, see package ota.
+func checkForUpdate(logger *slog.Logger, updater *ota.Updater) {
+  manifest, available, err := updater.Check()
+  if err != nil {
+    logger.Error("ota check", slog.String("err", err.Error()))
+    return
+  }
+  if available {
+    logger.Warn("firmware update available", slog.String("version", manifest.Version))
+  }
+}
+
+func updateBitmap(d *epd2in9v2.PaperDisplay, c *http.HttpClient, buf []byte) error {
+  img, err := c.NewBodyRequest(targetBitmapRequestPath, buf)
+  if err != nil {
+    return err
+  }
+  return d.UpdateBitmap(img, false)
+}
+
+func drawLines(d *epd2in9v2.PaperDisplay, text string, startTime time.Time) (err error) {
   lines := strings.Split(text, "\n")
   if len(lines) <= 15 {
     return fmt.Errorf("invalid data input, got lines=%d", len(lines))
   }
 
+  // Updates inside the frame only draw, the refresh and its errors come with
+  // Commit.
+  d.Begin()
+  defer func() {
+    if cerr := d.Commit(); err == nil {
+      err = cerr
+    }
+  }()
+  // The server can show a QR code instead, e.g. a donation address.
+  for _, l := range lines[16:] {
+    if text, ok := strings.CutPrefix(l, "QR "); ok {
+      return d.ShowQRCode(text, text)
+    }
+  }
   d.UpdateLine(lines[5], 0)
   d.UpdateLineMedium(lines[6], 12)
   d.UpdateLine(lines[7], 30)
@@ -99,15 +341,156 @@ func drawLines(d *epd2in9v2.PaperDisplay, text string, startTime time.Time) erro
   d.UpdateLineMedium(lines[9], 47)
 
   d.UpdateLine(lines[11]+" - "+startTime.Format("02.01.2006 15:04"), 70)
-  d.UpdateLineMedium(lines[12], 82)
+  d.UpdateLine(lines[12], 82)
+  drawProgress(d, lines[12], lines[16:])
 
   d.UpdateLine(lines[14], 100)
   d.UpdateLineMedium(lines[15], 112)
+
+  history := parseHistory(lines[16:])
+  if history == nil {
+    if price, ok := parsePrice(lines[6]); ok {
+      priceHistory.Add(price)
+    }
+    history = priceHistory.Values(nil)
+  }
+  d.UpdateWidget(&widget.Sparkline{
+    Bounds: priceChartBounds,
+    Values: history,
+    Labels: true,
+  })
+
+  bars := parseMempool(lines[16:])
+  if bars == nil {
+    bars = parseFees(lines[15])
+  }
+  d.UpdateWidget(&widget.BarChart{
+    Bounds:      feeChartBounds,
+    Bars:        bars,
+    ValueLabels: true,
+    Gap:         2,
+  })
+  return nil
+}
+
+func drawProgress(d *epd2in9v2.PaperDisplay, halvingLine string, extra []string) {
+  halving := progressBounds
+  for _, l := range extra {
+    v, found := strings.CutPrefix(l, "DIFFICULTY ")
+    if !found {
+      continue
+    }
+    if value, total, ok := parseProgress(v); ok {
+      mid := (progressBounds.Min.X + progressBounds.Max.X) / 2
+      halving.Max.X = mid - 2
+      difficulty := progressBounds
+      difficulty.Min.X = mid + 2
+      d.UpdateWidget(&widget.ProgressBar{
+        Bounds: difficulty,
+        Value:  value,
+        Max:    total,
+        Label:  widget.Percent(value, total),
+      })
+    }
+    break
+  }
+  if value, total, ok := parseProgress(halvingLine); ok {
+    d.UpdateWidget(&widget.ProgressBar{
+      Bounds: halving,
+      Value:  value,
+      Max:    total,
+      Ticks:  4,
+      Label:  widget.Percent(value, total),
+    })
+  }
+}
+
+// parseProgress reads a line like " 209,924/1,050,000(19%)".
+func parseProgress(line string) (value, total float64, ok bool) {
+  line, _, _ = strings.Cut(strings.ReplaceAll(line, ",", ""), "(")
+  v, t, found := strings.Cut(strings.TrimSpace(line), "/")
+  if !found {
+    return 0, 0, false
+  }
+  value, err := strconv.ParseFloat(v, 64)
+  if err != nil {
+    return 0, 0, false
+  }
+  total, err = strconv.ParseFloat(t, 64)
+  return value, total, err == nil && total > 0
+}
+
+// parseFees reads the bars from a line like " 541 - 700 - 800".
+func parseFees(line string) []widget.Bar {
+  var bars []widget.Bar
+  for i, f := range strings.Split(line, "-") {
+    v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+    if err != nil || i >= len(feeLabels) {
+      return nil
+    }
+    bars = append(bars, widget.Bar{Label: feeLabels[i], Value: v})
+  }
+  return bars
+}
+
+// parseMempool returns the bars of a line like "MEMPOOL 1:24.5 2:12 3:8",
+// label and value of each bar separated by a colon, or nil if there is none.
+func parseMempool(lines []string) []widget.Bar {
+  for _, l := range lines {
+    fields := strings.Fields(l)
+    if len(fields) == 0 || fields[0] != "MEMPOOL" {
+      continue
+    }
+    bars := make([]widget.Bar, 0, len(fields)-1)
+    for _, f := range fields[1:] {
+      label, value, _ := strings.Cut(f, ":")
+      v, err := strconv.ParseFloat(value, 64)
+      if err != nil {
+        return nil
+      }
+      bars = append(bars, widget.Bar{Label: label, Value: v})
+    }
+    return bars
+  }
+  return nil
+}
+
+// parsePrice reads the price from a line like " $64,012   +58,213".
+func parsePrice(line string) (float64, bool) {
+  fields := strings.Fields(line)
+  if len(fields) == 0 {
+    return 0, false
+  }
+  v, err := strconv.ParseFloat(strings.NewReplacer("$", "", ",", "").Replace(fields[0]), 64)
+  return v, err == nil
+}
+
+// parseHistory returns the samples of a line like "HISTORY 63900 64012 ...",
+// or nil if there is none.
+func parseHistory(lines []string) []float64 {
+  for _, l := range lines {
+    fields := strings.Fields(l)
+    if len(fields) == 0 || fields[0] != "HISTORY" {
+      continue
+    }
+    values := make([]float64, 0, len(fields)-1)
+    for _, f := range fields[1:] {
+      v, err := strconv.ParseFloat(f, 64)
+      if err != nil {
+        return nil
+      }
+      values = append(values, v)
+    }
+    return values
+  }
   return nil
 }
 
 func testDrawing(logger *slog.Logger) {
-  d := epd2in9v2.NewPaperDisplay(logger)
+  d, err := epd2in9v2.NewPaperDisplay(logger)
+  if err != nil {
+    logger.Error("display", slog.String("err", err.Error()))
+  }
   text := "HTTP/1.1 200 OK\n" +
     "Date: Sat, 20 Apr 2024 13:42:50 GMT\n" +
     "Content-Length: 128\n" +
@@ -126,7 +509,9 @@ func testDrawing(logger *slog.Logger) {
     " 541 - 700 - 800\n" +
     "\n" +
     "\n"
-  drawLines(d, text, time.Now())
+  if err := drawLines(d, text, time.Now()); err != nil {
+    logger.Error("draw", slog.String("err", err.Error()))
+  }
 
   time.Sleep(60 * time.Second)
 }
@@ -0,0 +1,313 @@
+package canvas
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"math/bits"
+	"strconv"
+)
+
+var (
+	ErrFormat = errors.New("canvas: unsupported image format, expected PBM, XBM or monochrome BMP")
+	ErrData   = errors.New("canvas: truncated or invalid image data")
+	ErrSize   = errors.New("canvas: image too large")
+)
+
+// Limits of decoded images. The header is checked against them and against
+// the length of the data before anything is allocated, so a bogus header
+// cannot exhaust the memory of the microcontroller.
+const (
+	MaxImageSide  = 1 << 15
+	MaxImageBytes = 32 << 10
+)
+
+// checkSize reports whether a width x height bitmap is within the limits.
+func checkSize(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return ErrFormat
+	}
+	if width > MaxImageSide || height > MaxImageSide || (width+7)/8*height > MaxImageBytes {
+		return ErrSize
+	}
+	return nil
+}
+
+// Bitmap is a decoded 1-bit image: rows of packed bytes, leftmost pixel in
+// the highest bit, 1 meaning black. It implements image.Image.
+type Bitmap struct {
+	Width, Height int
+	Stride        int // bytes per row
+	Pix           []uint8
+}
+
+// NewBitmap returns a white bitmap of width x height pixels.
+func NewBitmap(width, height int) *Bitmap {
+	stride := (width + 7) / 8
+	return &Bitmap{Width: width, Height: height, Stride: stride, Pix: make([]uint8, stride*height)}
+}
+
+// Black reports whether the pixel at x, y is black, pixels outside are white.
+func (b *Bitmap) Black(x, y int) bool {
+	if x < 0 || y < 0 || x >= b.Width || y >= b.Height {
+		return false
+	}
+	return b.Pix[y*b.Stride+x/8]&(0x80>>uint(x%8)) != 0
+}
+
+func (b *Bitmap) setBlack(x, y int) {
+	b.Pix[y*b.Stride+x/8] |= 0x80 >> uint(x%8)
+}
+
+func (b *Bitmap) Bounds() image.Rectangle {
+	return image.Rect(0, 0, b.Width, b.Height)
+}
+
+func (b *Bitmap) ColorModel() color.Model {
+	return ColorModel
+}
+
+func (b *Bitmap) At(x, y int) color.Color {
+	if b.Black(x, y) {
+		return Black
+	}
+	return White
+}
+
+// Blit options.
+type BlitOptions struct {
+	// Transparent leaves the canvas unchanged under white pixels.
+	Transparent bool
+	// Invert swaps black and white before drawing, with Transparent the black
+	// pixels become the transparent ones.
+	Invert bool
+}
+
+// Blit draws b with its top left corner at x, y, clipped to the clip area.
+func (c *Canvas) Blit(x, y int, b *Bitmap, opt BlitOptions) {
+	r := b.Bounds().Add(image.Pt(x, y)).Intersect(c.clip)
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			black := b.Black(px-x, py-y) != opt.Invert
+			if !black && opt.Transparent {
+				continue
+			}
+			c.set(px, py, black)
+		}
+	}
+}
+
+// Decode detects the format of data and decodes it.
+func Decode(data []byte) (*Bitmap, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("P1")), bytes.HasPrefix(data, []byte("P4")):
+		return DecodePBM(data)
+	case bytes.HasPrefix(data, []byte("BM")):
+		return DecodeBMP(data)
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("#define")):
+		return DecodeXBM(data)
+	}
+	return nil, ErrFormat
+}
+
+// DecodePBM decodes a plain (P1) or binary (P4) portable bitmap.
+func DecodePBM(data []byte) (*Bitmap, error) {
+	if len(data) < 3 || data[0] != 'P' || (data[1] != '1' && data[1] != '4') {
+		return nil, ErrFormat
+	}
+	binaryFormat := data[1] == '4'
+	rest := data[2:]
+	var dims [2]int
+	for i := range dims {
+		var field []byte
+		field, rest = nextPBMField(rest)
+		n, err := strconv.Atoi(string(field))
+		if err != nil || n <= 0 {
+			return nil, ErrFormat
+		}
+		dims[i] = n
+	}
+	if err := checkSize(dims[0], dims[1]); err != nil {
+		return nil, err
+	}
+	if binaryFormat {
+		// Exactly one whitespace character separates the header from the raster.
+		if len(rest) == 0 || len(rest)-1 < (dims[0]+7)/8*dims[1] {
+			return nil, ErrData
+		}
+		b := NewBitmap(dims[0], dims[1])
+		copy(b.Pix, rest[1:])
+		return b, nil
+	}
+	// One character per pixel at least.
+	if len(rest) < dims[0]*dims[1] {
+		return nil, ErrData
+	}
+	b := NewBitmap(dims[0], dims[1])
+
+	i := 0
+	for _, c := range rest {
+		switch c {
+		case '0', '1':
+			if i < b.Width*b.Height && c == '1' {
+				b.setBlack(i%b.Width, i/b.Width)
+			}
+			i++
+		case ' ', '\t', '\r', '\n':
+		default:
+			return nil, ErrData
+		}
+	}
+	if i < b.Width*b.Height {
+		return nil, ErrData
+	}
+	return b, nil
+}
+
+// nextPBMField returns the next whitespace separated header field, skipping
+// comments, and the remaining data starting right after the field.
+func nextPBMField(data []byte) (field, rest []byte) {
+	for len(data) > 0 {
+		switch data[0] {
+		case ' ', '\t', '\r', '\n':
+			data = data[1:]
+		case '#':
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				data = data[i:]
+			} else {
+				data = nil
+			}
+		default:
+			end := 0
+			for end < len(data) && !bytes.ContainsRune([]byte(" \t\r\n#"), rune(data[end])) {
+				end++
+			}
+			return data[:end], data[end:]
+		}
+	}
+	return nil, nil
+}
+
+// DecodeXBM decodes an X bitmap as written by GIMP or ImageMagick:
+//
+//	#define icon_width 16
+//	#define icon_height 16
+//	static unsigned char icon_bits[] = { 0x00, 0x18, ... };
+func DecodeXBM(data []byte) (*Bitmap, error) {
+	width, height := xbmDefine(data, "_width"), xbmDefine(data, "_height")
+	start := bytes.IndexByte(data, '{')
+	if start < 0 {
+		return nil, ErrFormat
+	}
+	if err := checkSize(width, height); err != nil {
+		return nil, err
+	}
+	end := bytes.IndexByte(data[start:], '}')
+	// One value per byte, separated by commas.
+	if end < 0 || bytes.Count(data[start:start+end], []byte(","))+1 < (width+7)/8*height {
+		return nil, ErrData
+	}
+	b := NewBitmap(width, height)
+	i := 0
+	for _, field := range bytes.Split(data[start+1:start+end], []byte(",")) {
+		field = bytes.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(string(field), 0, 8)
+		if err != nil || i == len(b.Pix) {
+			return nil, ErrData
+		}
+		// XBM has the leftmost pixel in the lowest bit.
+		b.Pix[i] = bits.Reverse8(uint8(v))
+		i++
+	}
+	if i < len(b.Pix) {
+		return nil, ErrData
+	}
+	return b, nil
+}
+
+// xbmDefine returns the value of the first "#define <name><suffix> <value>",
+// or -1.
+func xbmDefine(data []byte, suffix string) int {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fields := bytes.Fields(line)
+		if len(fields) == 3 && string(fields[0]) == "#define" && bytes.HasSuffix(fields[1], []byte(suffix)) {
+			if n, err := strconv.Atoi(string(fields[2])); err == nil {
+				return n
+			}
+		}
+	}
+	return -1
+}
+
+// DecodeBMP decodes an uncompressed 1 bit per pixel Windows bitmap. Which
+// palette entry is black is taken from the palette.
+func DecodeBMP(data []byte) (*Bitmap, error) {
+	if len(data) < 54 || data[0] != 'B' || data[1] != 'M' {
+		return nil, ErrFormat
+	}
+	le := binary.LittleEndian
+	offset := int(le.Uint32(data[10:]))
+	headerSize := int(le.Uint32(data[14:]))
+	width := int(int32(le.Uint32(data[18:])))
+	height := int(int32(le.Uint32(data[22:])))
+	bpp := le.Uint16(data[28:])
+	compression := le.Uint32(data[30:])
+	if headerSize < 40 || bpp != 1 || compression != 0 || width <= 0 || height == 0 {
+		return nil, ErrFormat
+	}
+	topDown := height < 0
+	if topDown {
+		height = -height
+	}
+	// Bounded sizes keep the arithmetic below from overflowing a 32 bit int.
+	if err := checkSize(width, height); err != nil {
+		return nil, err
+	}
+
+	palette := 14 + headerSize
+	if headerSize > len(data) || palette+8 > len(data) {
+		return nil, ErrData
+	}
+	var black [2]bool
+	for i := range black {
+		e := data[palette+4*i:]
+		black[i] = isBlack(color.RGBA{e[2], e[1], e[0], 255})
+	}
+
+	rowSize := (width + 31) / 32 * 4
+	if offset < 0 || offset > len(data)-rowSize*height {
+		return nil, ErrData
+	}
+	b := NewBitmap(width, height)
+	for y := 0; y < height; y++ {
+		src := y
+		if !topDown {
+			src = height - 1 - y
+		}
+		row := data[offset+src*rowSize:]
+		for i := 0; i < b.Stride; i++ {
+			v := row[i]
+			switch {
+			case black[0] && black[1]:
+				v = 0xFF
+			case !black[0] && !black[1]:
+				v = 0
+			case black[0]:
+				v = ^v
+			}
+			b.Pix[y*b.Stride+i] = v
+		}
+	}
+	// Clear the padding bits of the last byte of each row.
+	if pad := uint(b.Stride*8 - width); pad > 0 {
+		for y := 0; y < height; y++ {
+			b.Pix[y*b.Stride+b.Stride-1] &= 0xFF << pad
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,151 @@
+package canvas
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// The same 10 x 2 image in all formats: a diagonal pair of black pixels in
+// the first columns and the last pixel of the second row black.
+var wantPixels = [][2]int{{0, 0}, {1, 1}, {9, 1}}
+
+func checkPixels(t *testing.T, name string, b *Bitmap) {
+	t.Helper()
+	if b.Width != 10 || b.Height != 2 {
+		t.Fatalf("%s: size %dx%d, want 10x2", name, b.Width, b.Height)
+	}
+	n := 0
+	for y := 0; y < b.Height; y++ {
+		for x := 0; x < b.Width; x++ {
+			if b.Black(x, y) {
+				n++
+			}
+		}
+	}
+	for _, p := range wantPixels {
+		if !b.Black(p[0], p[1]) {
+			t.Errorf("%s: pixel %v not black", name, p)
+		}
+	}
+	if n != len(wantPixels) {
+		t.Errorf("%s: %d black pixels, want %d", name, n, len(wantPixels))
+	}
+}
+
+// bmp returns a 1 bit BMP of 10 x 2 pixels. Palette index 0 is black as
+// written by most tools, or white with inverted.
+func bmp(topDown, inverted bool) []byte {
+	const offset = 14 + 40 + 8
+	data := make([]byte, offset+2*4)
+	copy(data, "BM")
+	le := binary.LittleEndian
+	le.PutUint32(data[2:], uint32(len(data)))
+	le.PutUint32(data[10:], offset)
+	le.PutUint32(data[14:], 40)
+	le.PutUint32(data[18:], 10)
+	height := int32(2)
+	if topDown {
+		height = -2
+	}
+	le.PutUint32(data[22:], uint32(height))
+	le.PutUint16(data[26:], 1)
+	le.PutUint16(data[28:], 1)
+	palette := data[54:]
+	white := palette[4:]
+	if inverted {
+		white = palette
+	}
+	white[0], white[1], white[2] = 0xff, 0xff, 0xff
+	rows := [2][2]byte{{0x80, 0x00}, {0x40, 0x40}} // black bits, top first
+	for y, row := range rows {
+		dst := y
+		if !topDown {
+			dst = 1 - y
+		}
+		for i, v := range row {
+			if !inverted {
+				v = ^v
+			}
+			data[offset+dst*4+i] = v
+		}
+	}
+	return data
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"plain PBM", []byte("P1\n# comment\n10 2\n1 0 0 0 0 0 0 0 0 0\n0100000001\n")},
+		{"binary PBM", []byte("P4\n10 2\n\x80\x00\x40\x40")},
+		{"XBM", []byte("#define icon_width 10\n#define icon_height 2\nstatic unsigned char icon_bits[] = {\n 0x01, 0x00, 0x02, 0x02 };\n")},
+		{"BMP", bmp(false, false)},
+		{"top-down BMP", bmp(true, false)},
+		{"BMP with inverted palette", bmp(false, true)},
+	}
+	for _, tt := range tests {
+		b, err := Decode(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		checkPixels(t, tt.name, b)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	huge := bmp(false, false)
+	binary.LittleEndian.PutUint32(huge[18:], 0x7fffffff)
+	binary.LittleEndian.PutUint32(huge[22:], 0x7fffffff)
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"unknown", []byte("GIF89a"), ErrFormat},
+		{"huge PBM", []byte("P4 40000 40000\n\x00"), ErrSize},
+		{"PBM over the byte limit", []byte("P4 30000 30000\n\x00"), ErrSize},
+		{"short PBM", []byte("P4\n10 2\n\x80"), ErrData},
+		{"short plain PBM", []byte("P1 10 2 1 0 1"), ErrData},
+		{"plain PBM with garbage", []byte("P1 2 1 1 x"), ErrData},
+		{"huge XBM", []byte("#define i_width 30000\n#define i_height 30000\nstatic char i_bits[] = { 0x00 };"), ErrSize},
+		{"short XBM", []byte("#define i_width 10\n#define i_height 2\nstatic char i_bits[] = { 0x00 };"), ErrData},
+		{"huge BMP", huge, ErrSize},
+		{"short BMP", bmp(false, false)[:60], ErrData},
+	}
+	for _, tt := range tests {
+		if _, err := Decode(tt.data); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestBlit(t *testing.T) {
+	b, err := DecodePBM([]byte("P1 2 2 1 0 0 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(8, 8)
+	c.Fill(Black)
+	c.Blit(3, 3, b, BlitOptions{})
+	if !c.black(3, 3) || c.black(4, 3) || !c.black(4, 4) {
+		t.Error("opaque blit does not copy black and white")
+	}
+	c.Fill(Black)
+	c.Blit(3, 3, b, BlitOptions{Transparent: true})
+	if !c.black(4, 3) {
+		t.Error("transparent blit overwrote the canvas with white")
+	}
+	c.Fill(White)
+	c.Blit(3, 3, b, BlitOptions{Invert: true})
+	if c.black(3, 3) || !c.black(4, 3) {
+		t.Error("inverted blit did not swap black and white")
+	}
+	c.Fill(White)
+	c.Blit(7, 7, b, BlitOptions{}) // clipped at the edge
+	if !c.black(7, 7) {
+		t.Error("clipped blit lost the visible pixel")
+	}
+}
@@ -0,0 +1,229 @@
+// Package canvas is an in-memory 1-bit image with the memory layout of the
+// e-paper controller: rows of packed bytes, leftmost pixel in the highest bit,
+// 1 meaning white. It implements draw.Image, so everything drawn on it can be
+// composed off-screen and checked on the host before a Device flushes it.
+package canvas
+
+import (
+	"image"
+	"image/color"
+)
+
+// Rotation is clock-wise. Drawing uses logical coordinates, the rotation maps
+// them to the buffer.
+type Rotation uint8
+
+const (
+	NoRotation  Rotation = 0
+	Rotation90  Rotation = 1 // 90 degrees clock-wise rotation
+	Rotation180 Rotation = 2
+	Rotation270 Rotation = 3
+)
+
+var (
+	Black = color.RGBA{0, 0, 0, 255}
+	White = color.RGBA{255, 255, 255, 255}
+)
+
+// ColorModel converts any color to Black or White, whichever is closer in
+// luminance. Fully transparent colors become White.
+var ColorModel = color.ModelFunc(func(c color.Color) color.Color {
+	if isBlack(c) {
+		return Black
+	}
+	return White
+})
+
+type Canvas struct {
+	width    int16 // of the buffer, before rotation
+	height   int16
+	stride   int // bytes per buffer row
+	buf      []uint8
+	rotation Rotation
+	// Logical area drawing is limited to.
+	clip image.Rectangle
+	// Buffer area changed since the last ResetDirty, in buffer coordinates.
+	dirty image.Rectangle
+}
+
+// New returns a white canvas of width x height pixels. Rows are padded to
+// whole bytes.
+func New(width, height int16) *Canvas {
+	c := &Canvas{
+		width:  width,
+		height: height,
+		stride: (int(width) + 7) / 8,
+	}
+	c.buf = make([]uint8, c.stride*int(height))
+	c.clip = c.Bounds()
+	c.Fill(White)
+	return c
+}
+
+// Buffer returns the packed pixels, stride bytes per row.
+func (c *Canvas) Buffer() []uint8 {
+	return c.buf
+}
+
+// Stride returns the number of bytes per buffer row.
+func (c *Canvas) Stride() int {
+	return c.stride
+}
+
+// BufferSize returns the size of the buffer in pixels, before rotation.
+func (c *Canvas) BufferSize() (w, h int16) {
+	return c.width, c.height
+}
+
+func (c *Canvas) Rotation() Rotation {
+	return c.rotation
+}
+
+// SetRotation changes the rotation (clock-wise) and resets the clip area.
+func (c *Canvas) SetRotation(rotation Rotation) {
+	c.rotation = rotation
+	c.clip = c.Bounds()
+}
+
+// Size returns the logical size, width and height swap for 90 and 270
+// degrees.
+func (c *Canvas) Size() (w, h int16) {
+	if c.rotation == Rotation90 || c.rotation == Rotation270 {
+		return c.height, c.width
+	}
+	return c.width, c.height
+}
+
+// Bounds implements image.Image in logical coordinates.
+func (c *Canvas) Bounds() image.Rectangle {
+	w, h := c.Size()
+	return image.Rect(0, 0, int(w), int(h))
+}
+
+func (c *Canvas) ColorModel() color.Model {
+	return ColorModel
+}
+
+// Clip returns the logical area drawing is limited to.
+func (c *Canvas) Clip() image.Rectangle {
+	return c.clip
+}
+
+// SetClip limits drawing to r, in logical coordinates. Pixels outside are
+// silently dropped.
+func (c *Canvas) SetClip(r image.Rectangle) {
+	c.clip = r.Intersect(c.Bounds())
+}
+
+// ResetClip allows drawing on the whole canvas again.
+func (c *Canvas) ResetClip() {
+	c.clip = c.Bounds()
+}
+
+func (c *Canvas) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(c.Bounds())) {
+		return White
+	}
+	if c.black(x, y) {
+		return Black
+	}
+	return White
+}
+
+// black reports whether the pixel at the logical x, y inside the bounds is
+// black.
+func (c *Canvas) black(x, y int) bool {
+	bx, by := c.toBuffer(x, y)
+	return c.buf[by*c.stride+bx/8]&(0x80>>uint(bx%8)) == 0
+}
+
+// Set implements draw.Image, col is mapped with ColorModel.
+func (c *Canvas) Set(x, y int, col color.Color) {
+	c.set(x, y, isBlack(col))
+}
+
+// SetPixel is Set with the signature of tinygo drivers.
+func (c *Canvas) SetPixel(x, y int16, col color.RGBA) {
+	c.set(int(x), int(y), isBlack(col))
+}
+
+func (c *Canvas) set(x, y int, black bool) {
+	if !(image.Point{x, y}.In(c.clip)) {
+		return
+	}
+	bx, by := c.toBuffer(x, y)
+	i := by*c.stride + bx/8
+	mask := uint8(0x80) >> uint(bx%8)
+	if black {
+		c.buf[i] &^= mask
+	} else {
+		c.buf[i] |= mask
+	}
+	c.markDirty(bx, by)
+}
+
+// Fill sets all pixels to col, ignoring the clip area.
+func (c *Canvas) Fill(col color.Color) {
+	b := uint8(0xFF)
+	if isBlack(col) {
+		b = 0x00
+	}
+	for i := range c.buf {
+		c.buf[i] = b
+	}
+	c.MarkAllDirty()
+}
+
+// Dirty returns the buffer area changed since the last ResetDirty, in buffer
+// coordinates. It is empty if nothing changed.
+func (c *Canvas) Dirty() image.Rectangle {
+	return c.dirty
+}
+
+// MarkAllDirty marks the whole buffer as changed, e.g. after writing to
+// Buffer directly.
+func (c *Canvas) MarkAllDirty() {
+	c.dirty = image.Rect(0, 0, int(c.width), int(c.height))
+}
+
+// ResetDirty is called once the changes were flushed.
+func (c *Canvas) ResetDirty() {
+	c.dirty = image.Rectangle{}
+}
+
+func (c *Canvas) markDirty(bx, by int) {
+	if c.dirty.Empty() {
+		c.dirty = image.Rect(bx, by, bx+1, by+1)
+		return
+	}
+	c.dirty.Min.X = min(c.dirty.Min.X, bx)
+	c.dirty.Min.Y = min(c.dirty.Min.Y, by)
+	c.dirty.Max.X = max(c.dirty.Max.X, bx+1)
+	c.dirty.Max.Y = max(c.dirty.Max.Y, by+1)
+}
+
+// toBuffer maps logical to buffer coordinates according to the rotation.
+func (c *Canvas) toBuffer(x, y int) (int, int) {
+	w, h := int(c.width), int(c.height)
+	switch c.rotation {
+	case Rotation90:
+		return w - y - 1, x
+	case Rotation180:
+		return w - x - 1, h - y - 1
+	case Rotation270:
+		return y, h - x - 1
+	}
+	return x, y
+}
+
+// isBlack reports whether c is darker than mid gray. Colors are
+// alpha-premultiplied, so a transparent pixel counts as white.
+func isBlack(c color.Color) bool {
+	r, g, b, a := c.RGBA()
+	if a == 0 {
+		return false
+	}
+	// Luminance as in color.GrayModel, scaled back to full alpha.
+	y := (19595*r + 38470*g + 7471*b + 1<<15) >> 16
+	return y*0xffff < 0x8000*a
+}
@@ -0,0 +1,95 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRotation(t *testing.T) {
+	// The logical origin of a 16 x 8 buffer lands on these buffer pixels.
+	tests := []struct {
+		rotation Rotation
+		size     image.Point
+		origin   image.Point
+	}{
+		{NoRotation, image.Pt(16, 8), image.Pt(0, 0)},
+		{Rotation90, image.Pt(8, 16), image.Pt(15, 0)},
+		{Rotation180, image.Pt(16, 8), image.Pt(15, 7)},
+		{Rotation270, image.Pt(8, 16), image.Pt(0, 7)},
+	}
+	for _, tt := range tests {
+		c := New(16, 8)
+		c.SetRotation(tt.rotation)
+		if got := c.Bounds().Size(); got != tt.size {
+			t.Errorf("rotation %d: size %v, want %v", tt.rotation, got, tt.size)
+		}
+		c.ResetDirty()
+		c.Set(0, 0, Black)
+		want := image.Rect(tt.origin.X, tt.origin.Y, tt.origin.X+1, tt.origin.Y+1)
+		if got := c.Dirty(); got != want {
+			t.Errorf("rotation %d: dirty %v, want %v", tt.rotation, got, want)
+		}
+		b := c.Buffer()[tt.origin.Y*c.Stride()+tt.origin.X/8]
+		if b&(0x80>>uint(tt.origin.X%8)) != 0 {
+			t.Errorf("rotation %d: buffer pixel %v not black", tt.rotation, tt.origin)
+		}
+		if c.At(0, 0) != Black {
+			t.Errorf("rotation %d: At(0, 0) is not black", tt.rotation)
+		}
+	}
+}
+
+func TestRotationRoundTrip(t *testing.T) {
+	for _, r := range []Rotation{NoRotation, Rotation90, Rotation180, Rotation270} {
+		c := New(13, 7) // padded rows
+		c.SetRotation(r)
+		b := c.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c.Fill(White)
+				c.Set(x, y, Black)
+				if n := len(blackPixels(c)); n != 1 || !c.black(x, y) {
+					t.Fatalf("rotation %d: Set(%d, %d) gives %d black pixels", r, x, y, n)
+				}
+			}
+		}
+	}
+}
+
+func TestClip(t *testing.T) {
+	c := New(16, 16)
+	c.SetClip(image.Rect(4, 4, 8, 8))
+	c.Set(3, 4, Black)
+	c.Set(8, 7, Black)
+	if len(blackPixels(c)) != 0 {
+		t.Error("pixels outside the clip area were set")
+	}
+	c.Set(4, 4, Black)
+	if !c.black(4, 4) {
+		t.Error("pixel inside the clip area was not set")
+	}
+	c.SetClip(image.Rect(-10, -10, 100, 100))
+	if c.Clip() != c.Bounds() {
+		t.Errorf("clip %v not limited to the bounds %v", c.Clip(), c.Bounds())
+	}
+}
+
+func TestColorModel(t *testing.T) {
+	tests := []struct {
+		c     color.Color
+		black bool
+	}{
+		{color.Black, true},
+		{color.White, false},
+		{color.RGBA{0x40, 0x40, 0x40, 0xff}, true},
+		{color.RGBA{0xc0, 0xc0, 0xc0, 0xff}, false},
+		{color.Transparent, false},
+		{color.RGBA{0, 0, 0, 0x80}, true},
+	}
+	for _, tt := range tests {
+		if got := isBlack(tt.c); got != tt.black {
+			t.Errorf("isBlack(%v) = %v, want %v", tt.c, got, tt.black)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package canvas
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// All shapes use logical coordinates: the origin is the top left corner of
+// the rotated canvas, x grows to the right and y downwards. Rectangles are
+// image.Rectangle, so Max is exclusive: image.Rect(0, 0, 10, 5) covers the
+// pixels 0..9 x 0..4. Everything is clipped to the clip area.
+
+// Line draws a 1 pixel line from x0, y0 to x1, y1, both ends included.
+func (c *Canvas) Line(x0, y0, x1, y1 int, col color.Color) {
+	black := isBlack(col)
+	dx, sx := abs(x1-x0), 1
+	if x1 < x0 {
+		sx = -1
+	}
+	dy, sy := -abs(y1-y0), 1
+	if y1 < y0 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		c.set(x0, y0, black)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		// Both steps are decided on the error before either of them.
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// HLine draws a horizontal line of length pixels starting at x, y.
+func (c *Canvas) HLine(x, y, length int, col color.Color) {
+	c.FillRect(image.Rect(x, y, x+length, y+1), col)
+}
+
+// VLine draws a vertical line of length pixels starting at x, y.
+func (c *Canvas) VLine(x, y, length int, col color.Color) {
+	c.FillRect(image.Rect(x, y, x+1, y+length), col)
+}
+
+// Rect draws the 1 pixel outline of r.
+func (c *Canvas) Rect(r image.Rectangle, col color.Color) {
+	r = r.Canon()
+	if r.Empty() {
+		return
+	}
+	c.HLine(r.Min.X, r.Min.Y, r.Dx(), col)
+	c.HLine(r.Min.X, r.Max.Y-1, r.Dx(), col)
+	c.VLine(r.Min.X, r.Min.Y, r.Dy(), col)
+	c.VLine(r.Max.X-1, r.Min.Y, r.Dy(), col)
+}
+
+// FillRect fills r.
+func (c *Canvas) FillRect(r image.Rectangle, col color.Color) {
+	black := isBlack(col)
+	r = r.Canon().Intersect(c.clip)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c.set(x, y, black)
+		}
+	}
+}
+
+// Invert swaps black and white in r.
+func (c *Canvas) Invert(r image.Rectangle) {
+	r = r.Canon().Intersect(c.clip)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c.set(x, y, !c.black(x, y))
+		}
+	}
+}
+
+// RoundRect draws the outline of r with corners of the given radius, limited
+// to half the shorter side.
+func (c *Canvas) RoundRect(r image.Rectangle, radius int, col color.Color) {
+	r, radius = roundRect(r, radius)
+	if r.Empty() {
+		return
+	}
+	black := isBlack(col)
+	c.HLine(r.Min.X+radius, r.Min.Y, r.Dx()-2*radius, col)
+	c.HLine(r.Min.X+radius, r.Max.Y-1, r.Dx()-2*radius, col)
+	c.VLine(r.Min.X, r.Min.Y+radius, r.Dy()-2*radius, col)
+	c.VLine(r.Max.X-1, r.Min.Y+radius, r.Dy()-2*radius, col)
+	// Corner centers, the right and bottom ones on the last pixel inside r.
+	l, t := r.Min.X+radius, r.Min.Y+radius
+	rt, b := r.Max.X-1-radius, r.Max.Y-1-radius
+	circlePoints(radius, func(dx, dy int) {
+		c.set(rt+dx, b+dy, black)
+		c.set(l-dx, b+dy, black)
+		c.set(rt+dx, t-dy, black)
+		c.set(l-dx, t-dy, black)
+	})
+}
+
+// FillRoundRect fills r with corners of the given radius, limited to half the
+// shorter side.
+func (c *Canvas) FillRoundRect(r image.Rectangle, radius int, col color.Color) {
+	r, radius = roundRect(r, radius)
+	if r.Empty() {
+		return
+	}
+	c.FillRect(image.Rect(r.Min.X, r.Min.Y+radius, r.Max.X, r.Max.Y-radius), col)
+	l, t := r.Min.X+radius, r.Min.Y+radius
+	rt, b := r.Max.X-1-radius, r.Max.Y-1-radius
+	circlePoints(radius, func(dx, dy int) {
+		c.HLine(l-dx, t-dy, rt-l+2*dx+1, col)
+		c.HLine(l-dx, b+dy, rt-l+2*dx+1, col)
+	})
+}
+
+// Circle draws the outline of the circle around cx, cy.
+func (c *Canvas) Circle(cx, cy, radius int, col color.Color) {
+	black := isBlack(col)
+	circlePoints(radius, func(dx, dy int) {
+		c.set(cx+dx, cy+dy, black)
+		c.set(cx-dx, cy+dy, black)
+		c.set(cx+dx, cy-dy, black)
+		c.set(cx-dx, cy-dy, black)
+	})
+}
+
+// FillCircle fills the circle around cx, cy.
+func (c *Canvas) FillCircle(cx, cy, radius int, col color.Color) {
+	circlePoints(radius, func(dx, dy int) {
+		c.HLine(cx-dx, cy+dy, 2*dx+1, col)
+		c.HLine(cx-dx, cy-dy, 2*dx+1, col)
+	})
+}
+
+// Arc draws the part of the circle around cx, cy from start to end degrees.
+// Angles go clock-wise on screen, 0 pointing right and 90 down, so
+// Arc(cx, cy, r, 180, 360, col) is the upper half.
+func (c *Canvas) Arc(cx, cy, radius int, start, end float64, col color.Color) {
+	black := isBlack(col)
+	start = normalizeAngle(start)
+	sweep := end - start
+	if sweep >= 360 || sweep <= -360 {
+		c.Circle(cx, cy, radius, col)
+		return
+	}
+	sweep = normalizeAngle(sweep)
+	in := func(dx, dy int) bool {
+		a := normalizeAngle(math.Atan2(float64(dy), float64(dx))*180/math.Pi - start)
+		return a <= sweep
+	}
+	circlePoints(radius, func(dx, dy int) {
+		for _, p := range [4][2]int{{dx, dy}, {-dx, dy}, {dx, -dy}, {-dx, -dy}} {
+			if in(p[0], p[1]) {
+				c.set(cx+p[0], cy+p[1], black)
+			}
+		}
+	})
+}
+
+// circlePoints calls fn for the points of one quadrant (dx, dy >= 0) of a
+// circle around the origin, using the midpoint algorithm. Points may repeat.
+func circlePoints(radius int, fn func(dx, dy int)) {
+	if radius < 0 {
+		return
+	}
+	x, y := radius, 0
+	e := 1 - radius
+	for x >= y {
+		fn(x, y)
+		fn(y, x)
+		y++
+		if e < 0 {
+			e += 2*y + 1
+		} else {
+			x--
+			e += 2*(y-x) + 1
+		}
+	}
+}
+
+func roundRect(r image.Rectangle, radius int) (image.Rectangle, int) {
+	r = r.Canon()
+	radius = min(radius, (r.Dx()-1)/2, (r.Dy()-1)/2)
+	return r, max(radius, 0)
+}
+
+func normalizeAngle(a float64) float64 {
+	a = math.Mod(a, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,151 @@
+package canvas
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+// blackPixels returns the black pixels of c in logical coordinates.
+func blackPixels(c *Canvas) []image.Point {
+	var points []image.Point
+	b := c.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if c.black(x, y) {
+				points = append(points, image.Pt(x, y))
+			}
+		}
+	}
+	return points
+}
+
+// returns reports whether draw returns within a second. A hanging draw keeps
+// running in its goroutine, but the test fails instead of timing out.
+func returns(draw func()) bool {
+	done := make(chan struct{})
+	go func() {
+		draw()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+func TestLine(t *testing.T) {
+	const size = 12
+	for x0 := 0; x0 < size; x0 += 3 {
+		for y0 := 0; y0 < size; y0 += 3 {
+			for x1 := 0; x1 < size; x1++ {
+				for y1 := 0; y1 < size; y1++ {
+					c := New(16, 16)
+					c.Fill(White)
+					if !returns(func() { c.Line(x0, y0, x1, y1, Black) }) {
+						t.Fatalf("Line(%d, %d, %d, %d) did not return", x0, y0, x1, y1)
+					}
+
+					if !c.black(x0, y0) || !c.black(x1, y1) {
+						t.Errorf("Line(%d, %d, %d, %d): end points not set", x0, y0, x1, y1)
+					}
+					box := image.Rect(x0, y0, x1, y1).Canon()
+					box.Max = box.Max.Add(image.Pt(1, 1))
+					for _, p := range blackPixels(c) {
+						if !p.In(box) {
+							t.Errorf("Line(%d, %d, %d, %d): pixel %v outside %v", x0, y0, x1, y1, p, box)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLineLength(t *testing.T) {
+	tests := []struct {
+		x0, y0, x1, y1 int
+		pixels         int
+	}{
+		{0, 0, 0, 0, 1},
+		{0, 0, 7, 0, 8},
+		{0, 0, 0, 7, 8},
+		{0, 0, 7, 7, 8},
+		{7, 7, 0, 0, 8},
+		{0, 0, 2, 1, 3},
+		{0, 0, 9, 3, 10},
+	}
+	for _, tt := range tests {
+		c := New(16, 16)
+		c.Fill(White)
+		if !returns(func() { c.Line(tt.x0, tt.y0, tt.x1, tt.y1, Black) }) {
+			t.Fatalf("Line(%d, %d, %d, %d) did not return", tt.x0, tt.y0, tt.x1, tt.y1)
+		}
+		if n := len(blackPixels(c)); n != tt.pixels {
+			t.Errorf("Line(%d, %d, %d, %d) set %d pixels, want %d", tt.x0, tt.y0, tt.x1, tt.y1, n, tt.pixels)
+		}
+	}
+}
+
+func TestLineClipped(t *testing.T) {
+	c := New(16, 16)
+	c.Fill(White)
+	c.SetClip(image.Rect(4, 4, 8, 8))
+	if !returns(func() { c.Line(-20, -20, 30, 30, Black) }) {
+		t.Fatal("Line did not return")
+	}
+	for _, p := range blackPixels(c) {
+		if !p.In(image.Rect(4, 4, 8, 8)) {
+			t.Errorf("pixel %v outside the clip area", p)
+		}
+	}
+}
+
+func TestShapesStayInBounds(t *testing.T) {
+	r := image.Rect(2, 3, 13, 11)
+	tests := []struct {
+		name string
+		draw func(c *Canvas)
+	}{
+		{"Rect", func(c *Canvas) { c.Rect(r, Black) }},
+		{"FillRect", func(c *Canvas) { c.FillRect(r, Black) }},
+		{"RoundRect", func(c *Canvas) { c.RoundRect(r, 3, Black) }},
+		{"FillRoundRect", func(c *Canvas) { c.FillRoundRect(r, 3, Black) }},
+		{"Circle", func(c *Canvas) { c.Circle(7, 7, 4, Black) }},
+		{"FillCircle", func(c *Canvas) { c.FillCircle(7, 7, 4, Black) }},
+		{"Arc", func(c *Canvas) { c.Arc(7, 7, 4, 180, 360, Black) }},
+	}
+	circle := image.Rect(3, 3, 12, 12)
+	for _, tt := range tests {
+		c := New(16, 16)
+		c.Fill(White)
+		tt.draw(c)
+		bounds := r
+		if tt.name == "Circle" || tt.name == "FillCircle" || tt.name == "Arc" {
+			bounds = circle
+		}
+		points := blackPixels(c)
+		if len(points) == 0 {
+			t.Errorf("%s drew nothing", tt.name)
+		}
+		for _, p := range points {
+			if !p.In(bounds) {
+				t.Errorf("%s: pixel %v outside %v", tt.name, p, bounds)
+			}
+		}
+	}
+}
+
+func TestFillRectCorners(t *testing.T) {
+	c := New(16, 16)
+	c.Fill(White)
+	c.FillRect(image.Rect(2, 3, 5, 7), Black)
+	if n := len(blackPixels(c)); n != 12 {
+		t.Errorf("FillRect set %d pixels, want 12", n)
+	}
+	if !c.black(2, 3) || !c.black(4, 6) || c.black(5, 6) || c.black(4, 7) {
+		t.Error("FillRect does not cover Min inclusive and Max exclusive")
+	}
+}
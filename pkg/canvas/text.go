@@ -0,0 +1,53 @@
+package canvas
+
+import (
+	font_medium "orangeclock/pkg/font-medium"
+	font_small "orangeclock/pkg/font-small"
+)
+
+// Font is a fixed width bitmap font. Glyph returns the rows of a character
+// from top to bottom, 1 meaning black.
+type Font struct {
+	Glyph   func(r rune) [][]uint8
+	Width   int // of a glyph in pixels
+	Height  int
+	Spacing int // pixels between two characters
+}
+
+var (
+	FontSmall  = &Font{Glyph: font_small.RuneToBitmapFontSmall, Width: 6, Height: 6, Spacing: 1}
+	FontMedium = &Font{Glyph: font_medium.RuneToBitmapMedium, Width: 12, Height: 12, Spacing: 1}
+)
+
+// Advance returns the distance from one character to the next.
+func (f *Font) Advance() int {
+	return f.Width + f.Spacing
+}
+
+// TextWidth returns the width of text without the spacing after the last
+// character.
+func (f *Font) TextWidth(text string) int {
+	n := 0
+	for range text {
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return n*f.Advance() - f.Spacing
+}
+
+// Text draws black text on white with the top left corner of the first
+// character at x, y, in the same coordinates as the shapes. It returns the
+// x after the last character.
+func (c *Canvas) Text(x, y int, f *Font, text string) int {
+	for _, r := range text {
+		for row, pixels := range f.Glyph(r) {
+			for col, p := range pixels {
+				c.set(x+col, y+row, p == 1)
+			}
+		}
+		x += f.Advance()
+	}
+	return x
+}
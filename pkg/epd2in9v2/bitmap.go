@@ -0,0 +1,39 @@
+package epd2in9v2
+
+import (
+	"errors"
+	"orangeclock/pkg/canvas"
+)
+
+var errBitmapSize = errors.New("bitmap: image size does not match the display")
+
+// LoadBitmap replaces the buffer with a full-screen 1-bit image. The image is
+// in the panel's native orientation (width x height as the controller RAM is
+// laid out), the configured Rotation does not apply.
+//
+// data is either a raw packed bitmap of exactly bufferLength bytes, MSB first
+// and 1 meaning white like the buffer itself, or an image canvas.Decode
+// accepts (PBM, XBM or monochrome BMP). The buffer is left untouched if data
+// is not valid.
+func (d *Device) LoadBitmap(data []byte) error {
+	buffer, stride := d.canvas.Buffer(), d.canvas.Stride()
+	img, err := canvas.Decode(data)
+	if err != nil && uint32(len(data)) == d.bufferLength {
+		copy(buffer, data)
+		d.canvas.MarkAllDirty()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if img.Width != int(d.width) || img.Height != int(d.height) {
+		return errBitmapSize
+	}
+	for y := 0; y < img.Height; y++ {
+		for i, b := range img.Pix[y*img.Stride : (y+1)*img.Stride] {
+			buffer[y*stride+i] = ^b
+		}
+	}
+	d.canvas.MarkAllDirty()
+	return nil
+}
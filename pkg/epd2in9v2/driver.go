@@ -1,25 +1,50 @@
 package epd2in9v2
 
 import (
-	"fmt"
+	"errors"
+	"image"
 	"image/color"
 	"machine"
-	font_medium "orangeclock/pkg/font-medium"
-	font_small "orangeclock/pkg/font-small"
+	"math/bits"
+	"orangeclock/pkg/canvas"
 	"time"
 	"tinygo.org/x/drivers"
 )
 
 var (
-	Black = color.RGBA{1, 1, 1, 255}
-	White = color.RGBA{0, 0, 0, 255}
+	Black = canvas.Black
+	White = canvas.White
+	// ColorModel maps colors to Black or White, see canvas.ColorModel.
+	ColorModel = canvas.ColorModel
 )
 
+var errCanvasSize = errors.New("epd2in9v2: canvas size does not match the display")
+
+// ErrBusyTimeout is returned when the controller stays busy longer than the
+// busy timeout, e.g. because the panel is disconnected.
+var ErrBusyTimeout = errors.New("epd2in9v2: timeout waiting for the busy pin")
+
+// defaultBusyTimeout is well above the slowest refresh, the grayscale one in
+// the cold.
+const defaultBusyTimeout = 10 * time.Second
+
+var _ drivers.Displayer = (*Device)(nil)
+
 type Config struct {
 	Width        int16 // Width is the display resolution
 	Height       int16
 	LogicalWidth int16    // LogicalWidth must be a multiple of 8 and same size or bigger than Width
 	Rotation     Rotation // Rotation is clock-wise
+	// Temperature returns milli °C of the panel. It chooses the waveforms and
+	// is written to the controller for its OTP waveforms. If nil the RP2040's
+	// die sensor (machine.ReadTemperature) chooses the waveforms as a proxy
+	// for the board, and the controller measures with its own sensor.
+	Temperature func() int32
+	// KeepAwake leaves the controller awake after a refresh instead of
+	// putting it to deep sleep, which makes partial refreshes a bit faster.
+	KeepAwake bool
+	// BusyTimeout is how long to wait for the controller, 10s if 0.
+	BusyTimeout time.Duration
 }
 
 type Device struct {
@@ -31,12 +56,37 @@ type Device struct {
 	logicalWidth int16
 	width        int16
 	height       int16
-	buffer       []uint8
 	bufferLength uint32
-	rotation     Rotation
-}
-
-type Rotation uint8
+	// Image flushed to the panel, its dirty area is what changed since the
+	// last refresh.
+	canvas *canvas.Canvas
+	// High and low bit planes of the grayscale image, see DrawGray.
+	grayHigh *canvas.Canvas
+	grayLow  *canvas.Canvas
+	// Last reading of temperatureSensor, in milli °C.
+	temperature       int32
+	temperatureSensor func() int32
+	// Waveforms chosen with SetWaveforms, nil to pick by temperature.
+	fullWaveform    *Waveform
+	partialWaveform *Waveform
+	// What the panel shows, nil while unknown.
+	panel []uint8
+	// Number of refreshes since New, for telemetry.
+	fullRefreshes    uint32
+	partialRefreshes uint32
+	// Power state of the controller, see PowerState. grayMode is set while it
+	// is initialized for Gray4Display, loaded is the waveform sent last.
+	power     PowerState
+	grayMode  bool
+	loaded    *Waveform
+	keepAwake bool
+	// First error of the running operation. Once set, commands are no longer
+	// sent until the next operation starts.
+	err         error
+	busyTimeout time.Duration
+}
+
+type Rotation = canvas.Rotation
 
 var lutWF_Partial = [159]uint8{
 	0x0, 0x40, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
@@ -135,12 +185,33 @@ func (d *Device) Configure(cfg Config) {
 	} else {
 		d.height = 296
 	}
-	d.rotation = cfg.Rotation
+	d.temperatureSensor = cfg.Temperature
+	d.keepAwake = cfg.KeepAwake
+	if cfg.BusyTimeout != 0 {
+		d.busyTimeout = cfg.BusyTimeout
+	} else {
+		d.busyTimeout = defaultBusyTimeout
+	}
 	d.bufferLength = (uint32(d.logicalWidth) * uint32(d.height)) / 8
-	d.buffer = make([]uint8, d.bufferLength)
-	for i := uint32(0); i < d.bufferLength; i++ {
-		d.buffer[i] = 0xFF
+	d.canvas = canvas.New(d.logicalWidth, d.height)
+	d.canvas.SetRotation(cfg.Rotation)
+}
+
+// Canvas returns the image the Device flushes to the panel.
+func (d *Device) Canvas() *canvas.Canvas {
+	return d.canvas
+}
+
+// SetCanvas replaces the image flushed to the panel, e.g. with one composed
+// off-screen. It must have the size of the controller RAM, LogicalWidth x
+// Height. The next refresh sends all of it.
+func (d *Device) SetCanvas(c *canvas.Canvas) error {
+	if w, h := c.BufferSize(); w != d.logicalWidth || h != d.height {
+		return errCanvasSize
 	}
+	d.canvas = c
+	d.canvas.MarkAllDirty()
+	return nil
 }
 
 // Reset Software reset
@@ -153,45 +224,79 @@ func (d *Device) Reset() {
 	time.Sleep(10 * time.Millisecond)
 }
 
-// SendCommand sends a command to the display
-func (d *Device) SendCommand(command uint8) {
-	d.dc.Low()
-	d.cs.Low()
-	_, err := d.bus.Transfer(command)
-	if err != nil {
-		println(err)
-	}
-	d.cs.High()
+// SendCommand sends a command to the display. Nothing is sent after an
+// error of the running operation, which is returned again.
+func (d *Device) SendCommand(command uint8) error {
+	return d.transfer(false, command)
+}
+
+// SendData sends a data byte to the display, see SendCommand for errors.
+func (d *Device) SendData(data uint8) error {
+	return d.transfer(true, data)
 }
 
-// SendData sends a data byte to the display
-func (d *Device) SendData(data uint8) {
-	d.dc.High()
+func (d *Device) transfer(data bool, b uint8) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.dc.Set(data)
 	d.cs.Low()
-	_, err := d.bus.Transfer(data)
+	_, err := d.bus.Transfer(b)
+	d.cs.High()
 	if err != nil {
-		println(err)
+		d.err = err
 	}
-	d.cs.High()
+	return err
 }
 
-// ReadBusy waits until the busy_pin goes LOW
-func (d *Device) ReadBusy() {
+// ReadBusy waits until the busy_pin goes LOW, at most for timeout.
+func (d *Device) ReadBusy(timeout time.Duration) error {
+	if d.err != nil {
+		return d.err
+	}
+	deadline := time.Now().Add(timeout)
 	for d.busy.Get() {
+		if time.Now().After(deadline) {
+			d.err = ErrBusyTimeout
+			return d.err
+		}
 		time.Sleep(50 * time.Millisecond)
 	}
 	time.Sleep(50 * time.Millisecond)
+	return nil
+}
+
+// waitBusy waits for the controller with the configured timeout.
+func (d *Device) waitBusy() error {
+	return d.ReadBusy(d.busyTimeout)
+}
+
+// begin starts an operation, forgetting the error of the last one.
+func (d *Device) begin() {
+	d.err = nil
+}
+
+// end returns the error of the operation. After an error the state of the
+// controller and what the panel shows are unknown, so the next refresh
+// initializes it and sends all of the buffer.
+func (d *Device) end() error {
+	if d.err != nil {
+		d.power = PowerNeedsInit
+		d.panel = nil
+		d.canvas.MarkAllDirty()
+	}
+	return d.err
 }
 
-func (d *Device) Lut(lut [159]uint8) {
+func (d *Device) Lut(lut [159]uint8) error {
 	d.SendCommand(WRITE_LUT_REGISTER)
 	for i := 0; i < 153; i++ {
 		d.SendData(lut[i])
 	}
-	d.ReadBusy()
+	return d.waitBusy()
 }
 
-func (d *Device) LutByHost(lut [159]uint8) {
+func (d *Device) LutByHost(lut [159]uint8) error {
 	d.Lut(lut)
 	d.SendCommand(0x3f)
 	d.SendData(lut[153])
@@ -202,21 +307,34 @@ func (d *Device) LutByHost(lut [159]uint8) {
 	d.SendData(lut[156])                      // VSH2
 	d.SendData(lut[157])                      // VSL
 	d.SendCommand(WRITE_VCOM_REGISTER)        // VCOM
-	d.SendData(lut[158])
+	return d.SendData(lut[158])
 }
 
-func (d *Device) TurnOnDisplay() {
+func (d *Device) TurnOnDisplay() error {
 	d.SendCommand(DISPLAY_UPDATE_CONTROL_2)
 	d.SendData(0xc7)
 	d.SendCommand(MASTER_ACTIVATION)
-	d.ReadBusy()
+	if err := d.waitBusy(); err != nil {
+		return err
+	}
+	d.fullRefreshes++
+	return nil
 }
 
-func (d *Device) TurnOnDisplayPartial() {
+func (d *Device) TurnOnDisplayPartial() error {
 	d.SendCommand(DISPLAY_UPDATE_CONTROL_2)
 	d.SendData(0x0F)
 	d.SendCommand(MASTER_ACTIVATION)
-	d.ReadBusy()
+	if err := d.waitBusy(); err != nil {
+		return err
+	}
+	d.partialRefreshes++
+	return nil
+}
+
+// RefreshCounts returns the number of full and partial refreshes done so far.
+func (d *Device) RefreshCounts() (full, partial uint32) {
+	return d.fullRefreshes, d.partialRefreshes
 }
 
 // setWindows setting the display window
@@ -231,26 +349,32 @@ func (d *Device) setWindows(x0 int16, y0 int16, x1 int16, y1 int16) {
 	d.SendData(uint8((y1 >> 8) & 0xFF))
 }
 
-// setCursor moves the internal pointer to the specified coordinates
+// setCursor moves the internal pointer to the specified coordinates, x is
+// the RAM address in bytes of 8 pixels, y is the row.
 func (d *Device) setCursor(x int16, y int16) {
 	d.SendCommand(SET_RAM_X_ADDRESS_COUNTER)
-	d.SendData(uint8(x & 0xFF)) // TODO check from SetCursor
-	//d.SendData(uint8((x >> 3) & 0xFF))
+	d.SendData(uint8(x & 0xFF))
 
 	d.SendCommand(SET_RAM_Y_ADDRESS_COUNTER)
 	d.SendData(uint8(y & 0xFF))
 	d.SendData(uint8((y >> 8) & 0xFF))
-	d.ReadBusy()
+	d.waitBusy()
 }
 
 // Init initialize the e-paper register
-func (d *Device) Init() {
+func (d *Device) Init() error {
+	d.begin()
+	return d.init(d.fullWaveformFor())
+}
+
+// init initializes the controller and loads w.
+func (d *Device) init(w *Waveform) error {
 	d.Reset()
 	time.Sleep(100 * time.Millisecond)
 
-	d.ReadBusy()
+	d.waitBusy()
 	d.SendCommand(SW_RESET)
-	d.ReadBusy()
+	d.waitBusy()
 
 	d.SendCommand(DRIVER_OUTPUT_CONTROL)
 	d.SendData(0x27)
@@ -260,6 +384,9 @@ func (d *Device) Init() {
 	d.SendCommand(DATA_ENTRY_MODE_SETTING)
 	d.SendData(0x03)
 
+	d.SendCommand(TEMPERATURE_SENSOR_SELECTION)
+	d.SendData(0x80) // internal sensor
+
 	d.setWindows(0, 0, d.width-1, d.height-1)
 
 	d.SendCommand(DISPLAY_UPDATE_CONTROL_1)
@@ -267,18 +394,23 @@ func (d *Device) Init() {
 	d.SendData(0x80)
 
 	d.setCursor(0, 0)
-	d.ReadBusy()
+	d.waitBusy()
 
-	d.LutByHost(lutWS_20_30)
+	if err := d.loadWaveform(w); err != nil {
+		return d.end()
+	}
+	d.power, d.grayMode = PowerAwake, false
+	return nil
 }
 
-func (d *Device) Gray4Init() {
+func (d *Device) Gray4Init() error {
+	d.begin()
 	d.Reset()
 	time.Sleep(100 * time.Millisecond)
 
-	d.ReadBusy()
+	d.waitBusy()
 	d.SendCommand(0x12) // soft reset
-	d.ReadBusy()
+	d.waitBusy()
 
 	d.SendCommand(DRIVER_OUTPUT_CONTROL)
 	d.SendData(0x27)
@@ -294,13 +426,21 @@ func (d *Device) Gray4Init() {
 	d.SendData(0x04)
 
 	d.setCursor(1, 0)
-	d.ReadBusy()
+	d.waitBusy()
 
-	d.LutByHost(lutGray4)
+	d.loaded = nil
+	if err := d.LutByHost(lutGray4); err != nil {
+		return d.end()
+	}
+	d.power, d.grayMode = PowerAwake, true
+	return nil
 }
 
 // Clear clears the screen
-func (d *Device) Clear() {
+func (d *Device) Clear() error {
+	if err := d.wakeFull(); err != nil {
+		return err
+	}
 	d.SendCommand(WRITE_RAM)
 	for i := 0; i < 4736; i++ {
 		d.SendData(0xFF)
@@ -310,42 +450,85 @@ func (d *Device) Clear() {
 	for i := 0; i < 4736; i++ {
 		d.SendData(0xFF)
 	}
-	d.TurnOnDisplay()
+	if err := d.TurnOnDisplay(); err != nil {
+		return d.end()
+	}
+	// The panel no longer shows the buffer, the next partial refresh sends all of it.
+	d.canvas.MarkAllDirty()
+	d.syncPanel()
+	for i := range d.panel {
+		d.panel[i] = 0xFF
+	}
+	return d.refreshed()
+}
+
+// ClearBuffer sets all pixels in the buffer to white, the panel is unchanged.
+func (d *Device) ClearBuffer() {
+	d.canvas.Fill(White)
 }
 
 // Display Sends the image buffer in RAM to e-Paper and displays
-func (d *Device) Display() {
+func (d *Device) Display() error {
+	if err := d.wakeFull(); err != nil {
+		return err
+	}
+	buffer := d.canvas.Buffer()
 	d.SendCommand(WRITE_RAM)
 	for i := 0; i < 4736; i++ {
-		d.SendData(d.buffer[i])
+		d.SendData(buffer[i])
+	}
+	if err := d.TurnOnDisplay(); err != nil {
+		return d.end()
 	}
-	d.TurnOnDisplay()
+	d.canvas.ResetDirty()
+	d.syncPanel()
+	return d.refreshed()
 }
 
-func (d *Device) DisplayBase() {
+func (d *Device) DisplayBase() error {
+	if err := d.wakeFull(); err != nil {
+		return err
+	}
+	buffer := d.canvas.Buffer()
 	d.SendCommand(WRITE_RAM)
 	for i := 0; i < 4736; i++ {
-		d.SendData(d.buffer[i])
+		d.SendData(buffer[i])
 	}
 	d.SendCommand(0x26)
 	for i := 0; i < 4736; i++ {
-		d.SendData(d.buffer[i])
+		d.SendData(buffer[i])
 	}
-	d.TurnOnDisplay()
+	if err := d.TurnOnDisplay(); err != nil {
+		return d.end()
+	}
+	d.canvas.ResetDirty()
+	d.syncPanel()
+	return d.refreshed()
 }
 
-func (d *Device) Gray4Display() {
-	// todo implement
+// DisplayPartial sends the area of the buffer changed since the last refresh,
+// widened to whole bytes, and does a partial refresh. Nothing is done if the
+// buffer did not change.
+func (d *Device) DisplayPartial() error {
+	return d.displayPartial(d.partialWaveformFor())
 }
 
-func (d *Device) DisplayPartial() {
-	// reset
+func (d *Device) displayPartial(w *Waveform) error {
+	dirty := d.canvas.Dirty()
+	if dirty.Empty() {
+		return nil
+	}
+	if err := d.wakePartial(); err != nil {
+		return err
+	}
+	// reset, which also wakes the controller from deep sleep
 	d.rst.Low()
 	time.Sleep(time.Millisecond)
 	d.rst.High()
 	time.Sleep(2 * time.Millisecond)
+	d.power = PowerAwake
 
-	d.Lut(lutWF_Partial)
+	d.loadWaveform(w)
 	d.SendCommand(0x37)
 	d.SendData(0x00)
 	d.SendData(0x00)
@@ -364,77 +547,106 @@ func (d *Device) DisplayPartial() {
 	d.SendCommand(DISPLAY_UPDATE_CONTROL_2)
 	d.SendData(0xC0)
 	d.SendCommand(MASTER_ACTIVATION)
-	d.ReadBusy()
+	d.waitBusy()
 
-	d.setWindows(0, 0, d.width-1, d.height-1)
-	d.setCursor(0, 0)
+	x0, x1 := int16(dirty.Min.X)&^7, int16(dirty.Max.X-1)|7
+	y0, y1 := int16(dirty.Min.Y), int16(dirty.Max.Y-1)
+	d.setWindows(x0, y0, x1, y1)
+	d.setCursor(x0>>3, y0)
 
 	d.SendCommand(WRITE_RAM) // write Black and White image to RAM
-	for i := 0; i < 4736; i++ {
-		d.SendData(d.buffer[i])
+	buffer, stride := d.canvas.Buffer(), d.canvas.Stride()
+	for y := int(y0); y <= int(y1); y++ {
+		row := buffer[y*stride : (y+1)*stride]
+		for _, b := range row[x0>>3 : x1>>3+1] {
+			d.SendData(b)
+		}
 	}
-	d.TurnOnDisplayPartial()
+	if err := d.TurnOnDisplayPartial(); err != nil {
+		return d.end()
+	}
+	d.canvas.ResetDirty()
+	d.syncPanel()
+	return d.refreshed()
+}
+
+// Changed reports whether the buffer differs from what the panel shows. A
+// dirty area whose pixels all match the panel again is dropped, so a following
+// DisplayPartial does nothing.
+func (d *Device) Changed() bool {
+	return d.ChangedPixels() > 0
 }
 
-func (d *Device) Sleep() {
+// ChangedPixels returns the number of pixels in which the buffer differs from
+// what the panel shows, all of them while the panel content is unknown.
+func (d *Device) ChangedPixels() int {
+	dirty := d.canvas.Dirty()
+	if dirty.Empty() {
+		return 0
+	}
+	if d.panel == nil {
+		return int(d.bufferLength) * 8
+	}
+	buffer, stride := d.canvas.Buffer(), d.canvas.Stride()
+	changed := 0
+	for i := dirty.Min.Y * stride; i < dirty.Max.Y*stride; i++ {
+		changed += bits.OnesCount8(buffer[i] ^ d.panel[i])
+	}
+	if changed == 0 {
+		d.canvas.ResetDirty()
+	}
+	return changed
+}
+
+// syncPanel records that the panel shows the buffer. Areas outside the dirty
+// one are unchanged since the last refresh, so copying all of it is correct.
+func (d *Device) syncPanel() {
+	if d.panel == nil {
+		d.panel = make([]uint8, d.bufferLength)
+	}
+	copy(d.panel, d.canvas.Buffer())
+}
+
+func (d *Device) Sleep() error {
+	d.begin()
 	d.SendCommand(DEEP_SLEEP_MODE)
-	d.SendData(0x01)
+	if err := d.SendData(0x01); err != nil {
+		return d.end()
+	}
 	time.Sleep(100 * time.Millisecond)
+	d.power = PowerSleeping
+	return nil
 }
 
 // Paint
 
-func (d *Device) DrawStringSmall(startX, startY int16, text string) {
-	for rN, r := range text {
-		runeData := font_small.RuneToBitmapFontSmall(r)
-		nextCharPos := int16(rN*6) + int16(rN*1) // +1 for spacing
-		for x, rd := range runeData {
-			for y, p := range rd {
-				if p == 1 {
-					d.SetPixel(startX+int16(x), startY-nextCharPos-int16(y), Black)
-				} else {
-					d.SetPixel(startX+int16(x), startY-nextCharPos-int16(y), White)
-				}
-			}
-		}
-	}
+// DrawStringSmall draws text in the 6x6 font with the top left corner of the
+// first character at x, y. Like the shapes it uses the coordinates of the
+// configured Rotation: x grows to the right, y downwards.
+func (d *Device) DrawStringSmall(x, y int16, text string) {
+	d.canvas.Text(int(x), int(y), canvas.FontSmall, text)
 }
 
-func (d *Device) DrawStringMedium(startX, startY int16, text string) {
-	for rN, r := range text {
-		runeData := font_medium.RuneToBitmapMedium(r)
-		nextCharPos := int16(rN*12) + int16(rN*1) // +1 for spacing
-		for x, rd := range runeData {
-			for y, p := range rd {
-				if p == 1 {
-					d.SetPixel(startX+int16(x), startY-nextCharPos-int16(y), Black)
-				} else {
-					d.SetPixel(startX+int16(x), startY-nextCharPos-int16(y), White)
-				}
-			}
-		}
-	}
+// DrawStringMedium draws text in the 12x12 font, see DrawStringSmall.
+func (d *Device) DrawStringMedium(x, y int16, text string) {
+	d.canvas.Text(int(x), int(y), canvas.FontMedium, text)
 }
 
+// DrawRectangle draws the black outline of the width x height rectangle with
+// its top left corner at x, y. Like all shapes it uses the coordinates of the
+// configured Rotation, see package canvas for more shapes.
 func (d *Device) DrawRectangle(x, y, width, height int) {
-	// bottom Line
-	d.DrawHorizontalLine(x, y, width)
-	// top Line
-	d.DrawHorizontalLine(x+height-1, y, width)
-	d.DrawVerticalLine(x, y, height)
-	d.DrawVerticalLine(x, y+width-1, height)
+	d.canvas.Rect(image.Rect(x, y, x+width, y+height), Black)
 }
 
+// DrawVerticalLine draws a black line from x, y downwards.
 func (d *Device) DrawVerticalLine(x, y, length int) {
-	for i := 0; i < length; i++ {
-		d.SetPixelDefault(x+i, y)
-	}
+	d.canvas.VLine(x, y, length, Black)
 }
 
+// DrawHorizontalLine draws a black line from x, y to the right.
 func (d *Device) DrawHorizontalLine(x, y, length int) {
-	for i := 0; i < length; i++ {
-		d.SetPixelDefault(x, y+i)
-	}
+	d.canvas.HLine(x, y, length, Black)
 }
 
 // EXTRAS
@@ -444,47 +656,22 @@ func (d *Device) SetPixelDefault(x int, y int) {
 }
 
 // SetPixel modifies the internal buffer in a single pixel.
-// The display have 2 colors: black and white
-// We use RGBA(0,0,0, 255) as white (transparent)
-// Anything else as black
+// The display have 2 colors: black and white, c is mapped with ColorModel.
+// Pixels outside the display are dropped.
 func (d *Device) SetPixel(x int16, y int16, c color.RGBA) {
-	x, y = d.xy(x, y)
-	if x < 0 || x >= d.logicalWidth || y < 0 || y >= d.height {
-		fmt.Printf("Drawing out of space, width=%d, x=%d; height=%d, y=%d\n", d.width, x, d.height, y)
-		return
-	}
-	byteIndex := (int32(x) + int32(y)*int32(d.logicalWidth)) / 8
-	if c.R == 0 && c.G == 0 && c.B == 0 { // TRANSPARENT / WHITE
-		d.buffer[byteIndex] |= 0x80 >> uint8(x%8)
-	} else { // WHITE / EMPTY
-		d.buffer[byteIndex] &^= 0x80 >> uint8(x%8)
-	}
+	d.canvas.SetPixel(x, y, c)
 }
 
 // Size returns the current size of the display.
 func (d *Device) Size() (w, h int16) {
-	if d.rotation == ROTATION_90 || d.rotation == ROTATION_270 {
-		return d.height, d.logicalWidth
-	}
-	return d.logicalWidth, d.height
+	return d.canvas.Size()
 }
 
 // SetRotation changes the rotation (clock-wise) of the device
 func (d *Device) SetRotation(rotation Rotation) {
-	d.rotation = rotation
-}
-
-// xy chages the coordinates according to the rotation
-func (d *Device) xy(x, y int16) (int16, int16) {
-	switch d.rotation {
-	case NO_ROTATION:
-		return x, y
-	case ROTATION_90:
-		return d.width - y - 1, x
-	case ROTATION_180:
-		return d.width - x - 1, d.height - y - 1
-	case ROTATION_270:
-		return y, d.height - x - 1
-	}
-	return x, y
+	d.canvas.SetRotation(rotation)
+	if d.grayHigh != nil {
+		d.grayHigh.SetRotation(rotation)
+		d.grayLow.SetRotation(rotation)
+	}
 }
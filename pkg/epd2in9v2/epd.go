@@ -1,8 +1,12 @@
 package epd2in9v2
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"machine"
+	"orangeclock/pkg/canvas"
+	"orangeclock/pkg/qrcode"
 	"strings"
 	"time"
 )
@@ -12,13 +16,35 @@ const (
 	height = 296
 )
 
+// The display is used in landscape, 296 pixels wide and 128 high.
+const (
+	leftMargin = 9
+	// The wlan status is in the top right corner, with room for 8 characters.
+	statusLeft = height - 61
+)
+
+const diagnosticsLineLength = 40 // Characters of the small font fitting a line.
+
+var errQRCodeSize = errors.New("qr code does not fit the display")
+
+// ErrDisplay wraps the errors of the panel, as opposed to errors of the
+// content like an invalid image.
+var ErrDisplay = errors.New("display fault")
+
 type PaperDisplay struct {
 	Display Device
 	Status  string
 	logger  *slog.Logger
+	// Set while a full-screen page (diagnostics, QR code) covers the screen.
+	page bool
+	// Set between Begin and Commit, updates are drawn but not refreshed.
+	inFrame bool
+	policy  RefreshPolicy
 }
 
-func NewPaperDisplay(logger *slog.Logger) *PaperDisplay {
+// NewPaperDisplay configures the display on SPI1 and clears it. The
+// PaperDisplay is returned even if clearing fails, the next refresh retries.
+func NewPaperDisplay(logger *slog.Logger) (*PaperDisplay, error) {
 	err := machine.SPI1.Configure(machine.SPIConfig{
 		Frequency: 4_000_000,
 		Mode:      0,
@@ -37,59 +63,222 @@ func NewPaperDisplay(logger *slog.Logger) *PaperDisplay {
 		Width:        width,
 		Height:       height,
 		LogicalWidth: width,
-		Rotation:     ROTATION_180,
+		Rotation:     ROTATION_90,
 	})
 
-	display.Init()
-	display.Clear()
-	time.Sleep(2 * time.Second)
-
-	display.Sleep()
-	time.Sleep(2 * time.Second)
-	return &PaperDisplay{
+	d := &PaperDisplay{
 		Display: display,
 		logger:  logger,
+		policy:  &GhostingPolicy{MaxAge: 20 * time.Hour},
 	}
+	// Clear wakes the controller and puts it back to sleep.
+	return d, displayError(d.Display.Clear())
+}
+
+// displayError wraps err of the Device in ErrDisplay.
+func displayError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrDisplay, err)
+}
+
+// SetRefreshPolicy replaces the policy choosing between full, partial and no
+// refresh for each frame. The default does a full refresh every 20 hours.
+func (d *PaperDisplay) SetRefreshPolicy(policy RefreshPolicy) {
+	d.policy = policy
+}
+
+// Begin starts a frame. Updates until Commit only draw into the buffer, so
+// many elements are shown with a single refresh.
+func (d *PaperDisplay) Begin() {
+	d.inFrame = true
 }
 
-func (d *PaperDisplay) UpdateRawText(text string) {
+// Commit ends the frame started by Begin and refreshes the panel once.
+func (d *PaperDisplay) Commit() error {
+	d.inFrame = false
+	return d.refresh()
+}
+
+// refresh shows the buffer with the refresh the policy picks for the frame,
+// nothing is done while a frame is open. A failed refresh is not reported to
+// the policy, the Device sends all of the buffer next time.
+func (d *PaperDisplay) refresh() error {
+	if d.inFrame {
+		return nil
+	}
+	changed := d.Display.ChangedPixels()
+	r := d.policy.Decide(changed)
+	d.logger.Debug("refresh", slog.String("kind", r.String()), slog.Int("changed", changed))
+	var err error
+	switch r {
+	case RefreshSkip:
+		return nil
+	case RefreshFull:
+		err = d.fullRefresh()
+	default:
+		err = d.Display.DisplayPartial()
+	}
+	if err != nil {
+		return displayError(err)
+	}
+	d.policy.Refreshed(r, changed)
+	return nil
+}
+
+// fullRefresh shows the buffer with the full waveform, which removes the
+// ghosting left by partial refreshes.
+func (d *PaperDisplay) fullRefresh() error {
+	return d.Display.DisplayBase()
+}
+
+func (d *PaperDisplay) UpdateRawText(text string) error {
 	lines := strings.Split(text, "\n")
 	d.logger.Debug("got lines", slog.Int("count", len(lines)))
 	for i, l := range lines {
 		if l == "" || l == "\n" {
 			continue
 		}
-		d.Display.DrawStringSmall(int16(i*10), height-10, l)
+		d.Display.DrawStringSmall(leftMargin, int16(i*10), l)
 		d.logger.Debug("draw line at", slog.Int("pos", i*10))
 	}
-	d.Display.DisplayPartial()
+	return d.refresh()
 }
 
-func (d *PaperDisplay) UpdateLine(line string, x int) {
-	d.Display.DrawStringSmall(int16(x), height-10, line)
-	d.logger.Debug("draw line at", slog.Int("pos", x))
-	d.Display.DisplayPartial()
+// UpdateLine draws line in the small font with its top at y.
+func (d *PaperDisplay) UpdateLine(line string, y int) error {
+	d.leavePage()
+	d.Display.DrawStringSmall(leftMargin, int16(y), line)
+	d.logger.Debug("draw line at", slog.Int("pos", y))
+	return d.refresh()
 }
 
-func (d *PaperDisplay) UpdateLineMedium(line string, x int) {
-	d.Display.DrawStringMedium(int16(x), height-10, line)
-	d.logger.Debug("draw medium line at", slog.Int("pos", x))
-	d.Display.DisplayPartial()
+// UpdateLineMedium draws line in the medium font with its top at y.
+func (d *PaperDisplay) UpdateLineMedium(line string, y int) error {
+	d.leavePage()
+	d.Display.DrawStringMedium(leftMargin, int16(y), line)
+	d.logger.Debug("draw medium line at", slog.Int("pos", y))
+	return d.refresh()
 }
 
-func (d *PaperDisplay) UpdateWlanStatus(status string) {
+func (d *PaperDisplay) UpdateWlanStatus(status string) error {
+	d.leavePage()
 	if d.Status != status {
-		d.Display.DrawStringSmall(0, 60, status)
+		d.Display.DrawStringSmall(statusLeft, 0, status)
 		d.logger.Debug("update status on display")
 	}
-	d.Display.DisplayPartial()
 	d.Status = status
+	return d.refresh()
+}
+
+// Widget is anything that draws itself onto the canvas, see package widget.
+type Widget interface {
+	Draw(c *canvas.Canvas)
+}
+
+// UpdateWidget draws w in landscape coordinates, like the lines.
+func (d *PaperDisplay) UpdateWidget(w Widget) error {
+	d.leavePage()
+	w.Draw(d.Display.Canvas())
+	d.logger.Debug("draw widget")
+	return d.refresh()
+}
+
+// UpdateImage draws an icon or logo, see canvas.Decode for the formats, with
+// its top left corner at x, y. White pixels of the image are transparent.
+func (d *PaperDisplay) UpdateImage(data []byte, x, y int) error {
+	img, err := canvas.Decode(data)
+	if err != nil {
+		return err
+	}
+	d.leavePage()
+	d.Display.Canvas().Blit(x, y, img, canvas.BlitOptions{Transparent: true})
+	d.logger.Debug("draw image", slog.Int("x", x), slog.Int("y", y))
+	return d.refresh()
+}
+
+// ShowDiagnostics replaces the screen with a page of diagnostic lines, one per
+// row of the small font. Lines too long for the screen are cut. The next
+// update of regular content clears the page again.
+func (d *PaperDisplay) ShowDiagnostics(lines []string) error {
+	d.Display.ClearBuffer()
+	d.Display.DrawStringSmall(leftMargin, 0, "DIAGNOSTICS")
+	for i, l := range lines {
+		if len(l) > diagnosticsLineLength {
+			l = l[:diagnosticsLineLength]
+		}
+		d.Display.DrawStringSmall(leftMargin, int16((i+1)*10), strings.ToUpper(l))
+	}
+	d.logger.Debug("show diagnostics", slog.Int("lines", len(lines)))
+	d.page = true
+	return d.refresh()
 }
 
-func (d *PaperDisplay) ClearAndSleep() {
-	d.Display.Init()
-	d.Display.Clear()
-	time.Sleep(2 * time.Second)
-	d.Display.Sleep()
-	time.Sleep(2 * time.Second)
+// ShowQRCode replaces the screen with a QR code of text, as large as fits, and
+// caption in the small font right of it. The next update of regular content
+// clears the page again.
+func (d *PaperDisplay) ShowQRCode(text, caption string) error {
+	code, err := qrcode.Encode([]byte(text), qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	scale := width / (code.Size() + 2*qrcode.QuietZone)
+	if scale < 1 {
+		return errQRCodeSize
+	}
+	c := d.Display.Canvas()
+	d.Display.ClearBuffer()
+	r := code.Bounds(0, 0, scale, qrcode.QuietZone)
+	code.Draw(c, 0, (width-r.Dy())/2, scale, qrcode.QuietZone)
+	c.Text(r.Max.X, (width-canvas.FontSmall.Height)/2, canvas.FontSmall, strings.ToUpper(caption))
+	d.logger.Debug("show qr code", slog.Int("modules", code.Size()), slog.Int("scale", scale))
+	d.page = true
+	return d.refresh()
+}
+
+// leavePage clears a full-screen page before regular content is drawn and
+// brings back the wlan status.
+func (d *PaperDisplay) leavePage() {
+	if !d.page {
+		return
+	}
+	d.page = false
+	d.Display.ClearBuffer()
+	if d.Status != "" {
+		d.Display.DrawStringSmall(statusLeft, 0, d.Status)
+	}
+}
+
+// UpdateBitmap shows a full-screen image rendered by the server, see
+// Device.LoadBitmap for the accepted formats. With full set the panel does a
+// full refresh, otherwise the refresh policy decides.
+func (d *PaperDisplay) UpdateBitmap(data []byte, full bool) error {
+	d.page = false
+	if err := d.Display.LoadBitmap(data); err != nil {
+		return err
+	}
+	d.logger.Debug("draw bitmap", slog.Bool("full", full))
+	if !full {
+		return d.refresh()
+	}
+	changed := d.Display.ChangedPixels()
+	if err := d.fullRefresh(); err != nil {
+		return displayError(err)
+	}
+	d.policy.Refreshed(RefreshFull, changed)
+	return nil
+}
+
+func (d *PaperDisplay) ClearAndSleep() error {
+	if err := d.Display.Clear(); err != nil {
+		return displayError(err)
+	}
+	if d.Display.PowerState() != PowerSleeping {
+		if err := d.Display.Sleep(); err != nil {
+			return displayError(err)
+		}
+	}
+	d.policy.Refreshed(RefreshFull, 0)
+	return nil
 }
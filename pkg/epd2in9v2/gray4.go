@@ -0,0 +1,115 @@
+package epd2in9v2
+
+import (
+	"image"
+	"image/color"
+	"orangeclock/pkg/canvas"
+)
+
+// GrayLevel is a pixel value in 4-level grayscale mode. Its two bits are the
+// pixel's bits in the WRITE_RAM (high) and WRITE_RAM_RED (low) planes, which
+// lutGray4 turns into the four shades.
+type GrayLevel uint8
+
+const (
+	GrayBlack GrayLevel = 0b00
+	GrayLight GrayLevel = 0b01
+	GrayDark  GrayLevel = 0b10
+	GrayWhite GrayLevel = 0b11
+)
+
+// DrawGray draws on the grayscale image with any canvas primitive. The image
+// is two canvases of the display's size and rotation holding the high and the
+// low bit of each pixel's level; draw is called with each of them and the
+// color giving level in that plane, e.g.
+//
+//	d.DrawGray(GrayDark, func(c *canvas.Canvas, col color.RGBA) {
+//		c.FillCircle(40, 60, 20, col)
+//	})
+//
+// A clip area set on c applies to both planes. Gray4Display shows the
+// grayscale image, the 1-bit canvas is not touched.
+func (d *Device) DrawGray(level GrayLevel, draw func(c *canvas.Canvas, col color.RGBA)) {
+	high, low := d.grayPlanes()
+	draw(high, grayBit(level>>1))
+	draw(low, grayBit(level))
+}
+
+// grayBit returns the canvas color of a bit of a level, 1 is white.
+func grayBit(level GrayLevel) color.RGBA {
+	if level&1 != 0 {
+		return White
+	}
+	return Black
+}
+
+// SetGrayPixel sets a pixel of the grayscale image.
+func (d *Device) SetGrayPixel(x int16, y int16, level GrayLevel) {
+	d.DrawGray(level, func(c *canvas.Canvas, col color.RGBA) {
+		c.SetPixel(x, y, col)
+	})
+}
+
+// FillGray sets the whole grayscale image to level, ignoring the clip area.
+func (d *Device) FillGray(level GrayLevel) {
+	d.DrawGray(level, func(c *canvas.Canvas, col color.RGBA) {
+		c.Fill(col)
+	})
+}
+
+// FillGrayRectangle fills the w x h rectangle with its top left corner at x, y.
+func (d *Device) FillGrayRectangle(x, y, w, h int16, level GrayLevel) {
+	r := image.Rect(int(x), int(y), int(x+w), int(y+h))
+	d.DrawGray(level, func(c *canvas.Canvas, col color.RGBA) {
+		c.FillRect(r, col)
+	})
+}
+
+// DrawGrayRectangle draws the 1 pixel outline of the w x h rectangle with its
+// top left corner at x, y.
+func (d *Device) DrawGrayRectangle(x, y, w, h int16, level GrayLevel) {
+	r := image.Rect(int(x), int(y), int(x+w), int(y+h))
+	d.DrawGray(level, func(c *canvas.Canvas, col color.RGBA) {
+		c.Rect(r, col)
+	})
+}
+
+// grayPlanes returns the planes of the grayscale image, allocated white on
+// first use.
+func (d *Device) grayPlanes() (high, low *canvas.Canvas) {
+	if d.grayHigh == nil {
+		w, h := d.canvas.BufferSize()
+		d.grayHigh, d.grayLow = canvas.New(w, h), canvas.New(w, h)
+		d.grayHigh.SetRotation(d.canvas.Rotation())
+		d.grayLow.SetRotation(d.canvas.Rotation())
+	}
+	return d.grayHigh, d.grayLow
+}
+
+// Gray4Display sends the grayscale image to the panel and refreshes it. The
+// controller is switched to grayscale with Gray4Init if needed, the next
+// black and white refresh switches it back.
+func (d *Device) Gray4Display() error {
+	if err := d.wakeGray(); err != nil {
+		return err
+	}
+	high, low := d.grayPlanes()
+	for _, plane := range [2]struct {
+		cmd    uint8
+		canvas *canvas.Canvas
+	}{{WRITE_RAM, high}, {WRITE_RAM_RED, low}} {
+		d.SendCommand(plane.cmd)
+		for _, b := range plane.canvas.Buffer() {
+			d.SendData(b)
+		}
+	}
+	err := d.TurnOnDisplay()
+	d.panel = nil // Shows gray levels now, not the 1-bit buffer.
+	d.canvas.MarkAllDirty()
+	if err != nil {
+		return d.end()
+	}
+	high.ResetDirty()
+	low.ResetDirty()
+	return d.refreshed()
+}
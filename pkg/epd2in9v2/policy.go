@@ -0,0 +1,112 @@
+package epd2in9v2
+
+import "time"
+
+// Refresh is the kind of panel update done for a frame.
+type Refresh uint8
+
+const (
+	RefreshSkip Refresh = iota
+	RefreshPartial
+	RefreshFull
+)
+
+func (r Refresh) String() string {
+	switch r {
+	case RefreshSkip:
+		return "skip"
+	case RefreshPartial:
+		return "partial"
+	case RefreshFull:
+		return "full"
+	}
+	return "unknown"
+}
+
+// RefreshPolicy decides how PaperDisplay shows a frame. Partial refreshes are
+// fast and do not flash, but leave ghosts that only a full refresh removes.
+type RefreshPolicy interface {
+	// Decide is called for every frame with the number of pixels that differ
+	// from the panel.
+	Decide(changed int) Refresh
+	// Refreshed is called after the panel was updated with r, including full
+	// refreshes the policy did not ask for.
+	Refreshed(r Refresh, changed int)
+}
+
+// GhostingPolicy does partial refreshes until one of its limits is reached,
+// then a full one. Zero limits are not checked. Frames without changes are
+// skipped.
+type GhostingPolicy struct {
+	// Partial refreshes since the last full refresh.
+	MaxPartials int
+	// Pixels changed by partial refreshes since the last full refresh.
+	MaxChangedPixels int
+	// Time since the last full refresh.
+	MaxAge time.Duration
+	// Times of day, as offset from midnight, at which the next frame gets a
+	// full refresh, e.g. 3*time.Hour for a refresh at night.
+	FullAt []time.Duration
+	// Clock returns the wall time used for FullAt, time.Now if nil. The pico
+	// has no RTC, so the app passes the time it got from the server.
+	Clock func() time.Time
+
+	partials int
+	changed  int
+	lastFull time.Time
+}
+
+func (p *GhostingPolicy) now() time.Time {
+	if p.Clock != nil {
+		return p.Clock()
+	}
+	return time.Now()
+}
+
+func (p *GhostingPolicy) Decide(changed int) Refresh {
+	if changed == 0 {
+		return RefreshSkip
+	}
+	now := p.now()
+	if p.lastFull.IsZero() {
+		// The panel was cleared with a full refresh when it was set up.
+		p.lastFull = now
+	}
+	switch {
+	case p.MaxPartials > 0 && p.partials >= p.MaxPartials:
+		return RefreshFull
+	case p.MaxChangedPixels > 0 && p.changed+changed > p.MaxChangedPixels:
+		return RefreshFull
+	case p.MaxAge > 0 && now.Sub(p.lastFull) >= p.MaxAge:
+		return RefreshFull
+	}
+	for _, at := range p.FullAt {
+		if p.lastFull.Before(lastTimeOfDay(now, at)) {
+			return RefreshFull
+		}
+	}
+	return RefreshPartial
+}
+
+func (p *GhostingPolicy) Refreshed(r Refresh, changed int) {
+	switch r {
+	case RefreshFull:
+		p.partials = 0
+		p.changed = 0
+		p.lastFull = p.now()
+	case RefreshPartial:
+		p.partials++
+		p.changed += changed
+	}
+}
+
+// lastTimeOfDay returns the latest time not after now at the offset at from
+// midnight.
+func lastTimeOfDay(now time.Time, at time.Duration) time.Time {
+	y, m, d := now.Date()
+	t := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(at)
+	if t.After(now) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
@@ -0,0 +1,73 @@
+package epd2in9v2
+
+// PowerState tracks the controller between refreshes. Refreshes wake it as
+// needed and, unless Config.KeepAwake is set, put it back to deep sleep.
+type PowerState uint8
+
+const (
+	// PowerNeedsInit is the state after New, or after the grayscale mode for
+	// a black and white refresh: the registers have to be set up with Init.
+	PowerNeedsInit PowerState = iota
+	PowerAwake
+	// PowerSleeping is deep sleep mode 1, the RAM is kept. A partial refresh
+	// wakes the controller with a reset pulse, a full one initializes it.
+	PowerSleeping
+)
+
+func (s PowerState) String() string {
+	switch s {
+	case PowerNeedsInit:
+		return "needs init"
+	case PowerAwake:
+		return "awake"
+	case PowerSleeping:
+		return "sleeping"
+	}
+	return "unknown"
+}
+
+// PowerState returns the state of the controller.
+func (d *Device) PowerState() PowerState {
+	return d.power
+}
+
+// The wake functions start the operation of a refresh, see begin.
+
+// wakeFull makes the controller ready for a full black and white refresh.
+// A controller kept awake may still have a partial waveform loaded.
+func (d *Device) wakeFull() error {
+	d.begin()
+	if d.power != PowerAwake || d.grayMode || d.loaded == nil || d.loaded.Partial {
+		return d.Init()
+	}
+	return nil
+}
+
+// wakePartial makes the controller ready for a partial refresh, which
+// resets it on its own. Only a controller that never was set up for black
+// and white needs Init first.
+func (d *Device) wakePartial() error {
+	d.begin()
+	if d.power == PowerNeedsInit || d.grayMode {
+		return d.Init()
+	}
+	return nil
+}
+
+// wakeGray makes the controller ready for a grayscale refresh.
+func (d *Device) wakeGray() error {
+	d.begin()
+	if d.power != PowerAwake || !d.grayMode {
+		return d.Gray4Init()
+	}
+	return nil
+}
+
+// refreshed puts the controller to sleep after a successful refresh unless
+// it is kept awake.
+func (d *Device) refreshed() error {
+	if d.err != nil || d.keepAwake {
+		return d.end()
+	}
+	return d.Sleep()
+}
@@ -10,6 +10,7 @@ const (
 	DEEP_SLEEP_MODE                      = 0x10
 	DATA_ENTRY_MODE_SETTING              = 0x11
 	SW_RESET                             = 0x12
+	TEMPERATURE_SENSOR_SELECTION         = 0x18
 	TEMPERATURE_SENSOR_CONTROL           = 0x1A
 	MASTER_ACTIVATION                    = 0x20
 	DISPLAY_UPDATE_CONTROL_1             = 0x21
@@ -0,0 +1,90 @@
+package epd2in9v2
+
+import (
+	"machine"
+	"math"
+)
+
+// The particles of the panel move slower in the cold, so the waveforms have
+// to match the temperature. The host LUTs are made for 20 to 30°C, outside
+// of that the full refresh uses the controller's OTP LUT for the measured
+// temperature and partial refreshes drive the pixels longer below 10°C.
+// Waveforms set with SetWaveforms take precedence.
+type waveformSet struct {
+	minTemp int32 // milli °C, the set is used up to the next one
+	full    *Waveform
+	partial *Waveform
+}
+
+var waveformSets = []waveformSet{
+	{minTemp: math.MinInt32, full: waveformOTP, partial: waveformPartialCold},
+	{minTemp: 10_000, full: waveformOTP, partial: waveformPartial},
+	{minTemp: 20_000, full: waveformFull, partial: waveformPartial},
+	{minTemp: 30_000, full: waveformOTP, partial: waveformPartial},
+}
+
+// lutWF_PartialCold is lutWF_Partial with the frames of group 0 doubled.
+var lutWF_PartialCold = [159]uint8{
+	0x0, 0x40, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x80, 0x80, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x40, 0x40, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x80, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x14, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2,
+	0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x22, 0x22, 0x22, 0x22, 0x22, 0x22, 0x0, 0x0, 0x0,
+	0x22, 0x17, 0x41, 0xB0, 0x32, 0x36,
+}
+
+// Temperature returns the temperature in milli °C read before the last
+// refresh. Without Config.Temperature it is the RP2040's die temperature,
+// which only approximates the board: the chip warms itself and is accurate to
+// several °C.
+func (d *Device) Temperature() int32 {
+	return d.temperature
+}
+
+// readTemperature updates the reading and returns the waveforms for it. The
+// die sensor is only good enough to pick a set, see Config.Temperature.
+func (d *Device) readTemperature() waveformSet {
+	if d.temperatureSensor != nil {
+		d.temperature = d.temperatureSensor()
+	} else {
+		d.temperature = machine.ReadTemperature()
+	}
+	set := waveformSets[0]
+	for _, s := range waveformSets[1:] {
+		if d.temperature >= s.minTemp {
+			set = s
+		}
+	}
+	return set
+}
+
+// fullWaveformFor returns the waveform of the next full refresh.
+func (d *Device) fullWaveformFor() *Waveform {
+	set := d.readTemperature()
+	if d.fullWaveform != nil {
+		return d.fullWaveform
+	}
+	return set.full
+}
+
+// partialWaveformFor returns the waveform of the next partial refresh.
+func (d *Device) partialWaveformFor() *Waveform {
+	set := d.readTemperature()
+	if d.partialWaveform != nil {
+		return d.partialWaveform
+	}
+	return set.partial
+}
@@ -0,0 +1,221 @@
+package epd2in9v2
+
+import (
+	"errors"
+	"sync"
+)
+
+// LUT is a waveform table of the SSD1680: 153 bytes of voltage selections and
+// timing groups, then EOPT, VGH, VSH1, VSH2, VSL and VCOM.
+type LUT [159]uint8
+
+// Waveform is how the controller drives the pixels during a refresh.
+type Waveform struct {
+	Name string
+	// LUT is the table sent by the host, nil for the controller's OTP
+	// waveform.
+	LUT *LUT
+	// Partial waveforms only drive the pixels that changed since the last
+	// refresh. They load the LUT registers only, the voltages of the last
+	// full waveform stay.
+	Partial bool
+	// For OTP waveforms the controller picks its table by a temperature. If
+	// FixedTemperature is set it is Temperature in °C, otherwise the one of
+	// Config.Temperature or of the controller's own sensor. A high
+	// temperature gives a fast full refresh.
+	FixedTemperature bool
+	Temperature      int8
+}
+
+// The built-in waveforms picked by temperature, see waveformSets.
+var (
+	waveformFull    = &Waveform{Name: "full", LUT: (*LUT)(&lutWS_20_30)}
+	waveformPartial = &Waveform{Name: "partial", LUT: (*LUT)(&lutWF_Partial), Partial: true}
+	waveformOTP     = &Waveform{Name: "otp"}
+	// waveformPartialCold drives the pixels longer for partial refreshes in
+	// the cold.
+	waveformPartialCold = &Waveform{Name: "partial-cold", LUT: (*LUT)(&lutWF_PartialCold), Partial: true}
+)
+
+// Presets, always registered under their names. They are copies of the
+// built-in waveforms, changing them does not change the defaults.
+var (
+	WaveformFull = waveformFull.clone()
+	// WaveformPartial refreshes without flashing, but leaves ghosts.
+	WaveformPartial = waveformPartial.clone()
+	// WaveformFast is a full refresh with the OTP table for 100°C, about
+	// twice as fast as WaveformFull but with less contrast.
+	WaveformFast = &Waveform{Name: "fast", FixedTemperature: true, Temperature: 100}
+	// WaveformOTP is the controller's full waveform for the measured
+	// temperature.
+	WaveformOTP = waveformOTP.clone()
+)
+
+// clone returns a copy of w with its own LUT.
+func (w *Waveform) clone() *Waveform {
+	c := *w
+	if w.LUT != nil {
+		lut := *w.LUT
+		c.LUT = &lut
+	}
+	return &c
+}
+
+var (
+	errWaveformName    = errors.New("waveform: name missing")
+	errWaveformTiming  = errors.New("waveform: all timing groups are empty")
+	errWaveformVoltage = errors.New("waveform: driving voltage out of range")
+	errWaveformOTP     = errors.New("waveform: partial refresh needs a host LUT")
+	errWaveformPreset  = errors.New("waveform: name of a preset")
+)
+
+// Validate checks that w has a name and its LUT stays in the voltage ranges
+// of the controller's datasheet, which protects the panel.
+func (w *Waveform) Validate() error {
+	if w.Name == "" {
+		return errWaveformName
+	}
+	if w.LUT == nil {
+		if w.Partial {
+			return errWaveformOTP
+		}
+		return nil
+	}
+	l := w.LUT
+	var frames int
+	// 12 groups of TPA, TPB, SRAB, TPC, TPD, SRCD and RP after the 60 bytes
+	// of voltage selections.
+	for g := 0; g < 12; g++ {
+		group := l[60+7*g : 60+7*g+7]
+		frames += int(group[0]) + int(group[1]) + int(group[3]) + int(group[4])
+	}
+	if frames == 0 {
+		return errWaveformTiming
+	}
+	vgh, vsh1, vsh2, vsl, vcom := l[154], l[155], l[156], l[157], l[158]
+	sourceHigh := func(v uint8) bool {
+		return v == 0 || (v >= 0x23 && v <= 0x50) || (v >= 0x8E && v <= 0xCE)
+	}
+	if vgh > 0x17 || !sourceHigh(vsh1) || !sourceHigh(vsh2) ||
+		(vsl != 0 && (vsl < 0x1A || vsl > 0x3A)) || vcom < 0x08 || vcom > 0x78 {
+		return errWaveformVoltage
+	}
+	return nil
+}
+
+var (
+	waveformsMu sync.Mutex
+	waveforms   = map[string]*Waveform{}
+)
+
+// presets are the names RegisterWaveform refuses.
+var presets = map[string]bool{}
+
+func init() {
+	for _, w := range []*Waveform{WaveformFull, WaveformPartial, WaveformFast, WaveformOTP, waveformPartialCold} {
+		waveforms[w.Name] = w.clone()
+		presets[w.Name] = true
+	}
+}
+
+// RegisterWaveform validates a copy of w and makes it available by name,
+// replacing a waveform of the same name. The names of the presets are taken.
+func RegisterWaveform(w *Waveform) error {
+	w = w.clone()
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	if presets[w.Name] {
+		return errWaveformPreset
+	}
+	waveformsMu.Lock()
+	defer waveformsMu.Unlock()
+	waveforms[w.Name] = w
+	return nil
+}
+
+// LookupWaveform returns a copy of the waveform registered under name.
+func LookupWaveform(name string) (*Waveform, bool) {
+	waveformsMu.Lock()
+	defer waveformsMu.Unlock()
+	w, ok := waveforms[name]
+	if !ok {
+		return nil, false
+	}
+	return w.clone(), true
+}
+
+// SetWaveforms makes full refreshes (Init, Display, DisplayBase) and partial
+// refreshes use copies of the given waveforms. nil picks by temperature,
+// which is the default.
+func (d *Device) SetWaveforms(full, partial *Waveform) error {
+	if full != nil {
+		full = full.clone()
+		if err := full.Validate(); err != nil {
+			return err
+		}
+	}
+	if partial != nil {
+		partial = partial.clone()
+		if err := partial.Validate(); err != nil {
+			return err
+		}
+	}
+	d.fullWaveform, d.partialWaveform = full, partial
+	return nil
+}
+
+// DisplayWaveform shows the buffer with w for this refresh only. A partial
+// waveform sends the changed area like DisplayPartial, a full one
+// initializes the controller and sends all of the buffer.
+func (d *Device) DisplayWaveform(w *Waveform) error {
+	w = w.clone()
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	if w.Partial {
+		return d.displayPartial(w)
+	}
+	d.begin()
+	if err := d.init(w); err != nil {
+		return err
+	}
+	return d.DisplayBase()
+}
+
+// loadWaveform sends w to the controller.
+func (d *Device) loadWaveform(w *Waveform) error {
+	switch {
+	case w.LUT != nil && w.Partial:
+		d.Lut(*w.LUT)
+	case w.LUT != nil:
+		d.LutByHost(*w.LUT)
+	case w.FixedTemperature || d.temperatureSensor != nil:
+		temperature := w.Temperature
+		if !w.FixedTemperature {
+			// Fresh, the last reading may be old or not taken yet.
+			d.readTemperature()
+			temperature = int8(d.temperature / 1000)
+		}
+		// Let the controller pick from its OTP by the temperature.
+		d.SendCommand(TEMPERATURE_SENSOR_CONTROL)
+		d.SendData(uint8(temperature))
+		d.SendData(0x00)
+		d.SendCommand(DISPLAY_UPDATE_CONTROL_2)
+		d.SendData(0x91) // load LUT for the written temperature
+		d.SendCommand(MASTER_ACTIVATION)
+		d.waitBusy()
+	default:
+		// The die sensor of the RP2040 self-heats and is off by several
+		// degrees, the controller measures the panel itself.
+		d.SendCommand(DISPLAY_UPDATE_CONTROL_2)
+		d.SendData(0xB1) // read the internal sensor, load its LUT
+		d.SendCommand(MASTER_ACTIVATION)
+		d.waitBusy()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	d.loaded = w
+	return nil
+}
@@ -9,21 +9,38 @@ import (
   "math/rand"
   "net/netip"
   "orangeclock/pkg/wifi"
+  "strconv"
   "time"
 )
 
 const connTimeout = 5 * time.Second
+const maxResolveAttempts = 3 // ARP requests for the router per round trip
 const tcpbufsize = 2030 // MTU - ethhdr - iphdr - tcphdr
 const hostname = "pico-orangeclock"
 
 type HttpClient struct {
   logger     *slog.Logger
+  stack      *stacks.PortStack
+  dhcp       *stacks.DHCPClient
   svAddr     netip.AddrPort
   clientAddr netip.AddrPort
   conn       *stacks.TCPConn
   routerhw   [6]byte
   closeConn  func(err string)
   rng        *rand.Rand
+  leaseStart time.Time
+  lastErr    string
+}
+
+// Diagnostics describes the network state of the client, see HttpClient.Diagnostics.
+type Diagnostics struct {
+  IP             netip.Addr
+  Gateway        netip.Addr
+  DNS            netip.Addr
+  LeaseRemaining time.Duration
+  RouterHW       [6]byte
+  Server         netip.AddrPort
+  LastError      string
 }
 
 func NewHttpClient(logger *slog.Logger, target string) (*HttpClient, string, error) {
@@ -74,38 +91,183 @@ func NewHttpClient(logger *slog.Logger, target string) (*HttpClient, string, err
 
   return &HttpClient{
     logger:     logger,
+    stack:      stack,
+    dhcp:       dhcpc,
     svAddr:     svAddr,
     clientAddr: clientAddr,
     conn:       conn,
     routerhw:   routerhw,
     closeConn:  closeConn,
     rng:        rng,
+    leaseStart: start,
   }, ssid, nil
 }
 
+// Stack returns the network stack the client runs on.
+func (c *HttpClient) Stack() *stacks.PortStack {
+  return c.stack
+}
+
+// DHCP returns the DHCP client holding the lease of the stack.
+func (c *HttpClient) DHCP() *stacks.DHCPClient {
+  return c.dhcp
+}
+
+// Diagnostics returns the addresses obtained via DHCP, the time left on the
+// lease and the last HTTP error.
+func (c *HttpClient) Diagnostics() Diagnostics {
+  d := Diagnostics{
+    IP:        c.stack.Addr(),
+    Gateway:   c.dhcp.Gateway(),
+    RouterHW:  c.routerhw,
+    Server:    c.svAddr,
+    LastError: c.lastErr,
+  }
+  if dns := c.dhcp.DNSServers(); len(dns) > 0 {
+    d.DNS = dns[0]
+  }
+  if lease := c.dhcp.IPLeaseTime(); lease > 0 {
+    d.LeaseRemaining = max(lease-time.Since(c.leaseStart), 0)
+  }
+  return d
+}
+
 func (c *HttpClient) NewRequest(path string) (string, error) {
+  rxBuf := make([]byte, 4096)
+  n, err := c.roundTrip(c.requestHeader("GET", path, ""), rxBuf, false)
+  if err != nil {
+    return "", c.recordErr(err)
+  }
+  return string(rxBuf[:n]), nil
+}
+
+// NewBodyRequest requests path and returns the response body. The whole
+// response, header included, has to fit into buf; the body is returned as a
+// sub-slice of buf. Anything but a complete 200 OK is an error.
+func (c *HttpClient) NewBodyRequest(path string, buf []byte) ([]byte, error) {
+  return c.bodyRequest(c.requestHeader("GET", path, ""), buf, 200)
+}
+
+// NewPostRequest sends body to path with a POST request. Any 2xx answer is
+// taken as success, the response body is ignored.
+func (c *HttpClient) NewPostRequest(path, contentType string, body []byte) error {
+  reqbytes := c.requestHeader("POST", path, contentType, "Content-Length: "+strconv.Itoa(len(body)))
+  reqbytes = append(reqbytes, body...)
+  rxBuf := make([]byte, 512)
+  n, err := c.roundTrip(reqbytes, rxBuf, false)
+  if err != nil {
+    return c.recordErr(err)
+  }
+  status, _, _, err := splitResponse(rxBuf[:n])
+  if err != nil {
+    return c.recordErr(err)
+  }
+  if status < 200 || status > 299 {
+    return c.recordErr(errors.New("unexpected status " + strconv.Itoa(status)))
+  }
+  return nil
+}
+
+func (c *HttpClient) bodyRequest(reqbytes []byte, buf []byte, wantStatus int) ([]byte, error) {
+  body, err := c.readBody(reqbytes, buf, wantStatus)
+  if err != nil {
+    return nil, c.recordErr(err)
+  }
+  return body, nil
+}
+
+func (c *HttpClient) readBody(reqbytes []byte, buf []byte, wantStatus int) ([]byte, error) {
+  n, err := c.roundTrip(reqbytes, buf, true)
+  if err != nil {
+    return nil, err
+  }
+  status, body, contentLength, err := splitResponse(buf[:n])
+  if err != nil {
+    return nil, err
+  }
+  if status != wantStatus {
+    return nil, errors.New("unexpected status " + strconv.Itoa(status))
+  }
+  if contentLength >= 0 && len(body) < contentLength {
+    if n == len(buf) {
+      return nil, errors.New("response does not fit into buffer")
+    }
+    return nil, errors.New("short response body")
+  }
+  if contentLength >= 0 {
+    body = body[:contentLength]
+  }
+  return body, nil
+}
+
+// recordErr keeps err for Diagnostics and returns it.
+func (c *HttpClient) recordErr(err error) error {
+  c.lastErr = err.Error()
+  return err
+}
+
+// fail closes the connection after a failed attempt, reason is kept for Diagnostics.
+func (c *HttpClient) fail(reason string) {
+  c.lastErr = reason
+  c.closeConn(reason)
+}
+
+// requestHeader returns the request header for path. contentType is only
+// used for requests with a body. extraLines are complete header lines without
+// the trailing CRLF, e.g. "Content-Length: 42".
+func (c *HttpClient) requestHeader(method, path, contentType string, extraLines ...string) []byte {
   // Here we create the HTTP request and generate the bytes. The Header method
   // returns the raw header bytes as should be sent over the wire.
   var req httpx.RequestHeader
   req.SetRequestURI(path)
-  req.SetMethod("GET")
+  req.SetMethod(method)
   req.SetHost(c.svAddr.Addr().String())
-  reqbytes := req.Header()
+  if contentType != "" {
+    req.SetContentType(contentType)
+  }
+  hdr := req.Header()
+  if len(extraLines) == 0 {
+    return hdr
+  }
+  // Header ends with an empty line, insert the extra lines before it.
+  reqbytes := append([]byte{}, hdr[:len(hdr)-2]...)
+  for _, line := range extraLines {
+    reqbytes = append(reqbytes, line...)
+    reqbytes = append(reqbytes, "\r\n"...)
+  }
+  return append(reqbytes, "\r\n"...)
+}
 
+// roundTrip sends reqbytes to the server and reads the response into rxBuf.
+// With readAll set it keeps reading until the response is complete, the
+// buffer is full or the server stops sending, otherwise a single read is done.
+func (c *HttpClient) roundTrip(reqbytes []byte, rxBuf []byte, readAll bool) (int, error) {
   c.logger.Debug("tcp:ready",
     slog.String("clientaddr", c.clientAddr.String()),
     slog.String("serveraddr", c.svAddr.String()),
   )
-  rxBuf := make([]byte, 4096)
+  resolveAttempts := 0
   for {
     time.Sleep(5 * time.Second)
     c.logger.Debug("dialing", slog.String("serveraddr", c.svAddr.String()))
 
+    // The cached router address expires regularly, a new AP is picked up here.
+    routerhw, err := wifi.ResolveHardwareAddr(c.stack, c.dhcp.Router())
+    if err != nil {
+      c.lastErr = "resolving router: " + err.Error()
+      resolveAttempts++
+      if resolveAttempts >= maxResolveAttempts {
+        return 0, errors.New(c.lastErr)
+      }
+      continue
+    }
+    c.routerhw = routerhw
+
     // Make sure to timeout the connection if it takes too long.
     c.conn.SetDeadline(time.Now().Add(connTimeout))
-    err := c.conn.OpenDialTCP(c.clientAddr.Port(), c.routerhw, c.svAddr, seqs.Value(c.rng.Intn(65535-1024)+1024))
+    err = c.conn.OpenDialTCP(c.clientAddr.Port(), c.routerhw, c.svAddr, seqs.Value(c.rng.Intn(65535-1024)+1024))
     if err != nil {
-      c.closeConn("opening TCP: " + err.Error())
+      c.fail("opening TCP: " + err.Error())
       continue
     }
     retries := 50
@@ -115,28 +277,36 @@ func (c *HttpClient) NewRequest(path string) (string, error) {
     }
     c.conn.SetDeadline(time.Time{}) // Disable the deadline.
     if retries == 0 {
-      c.closeConn("tcp establish retry limit exceeded")
-      return "", errors.New("tcp establish retry limit exceeded")
+      wifi.InvalidateHardwareAddr(c.dhcp.Router()) // Maybe the router changed.
+      c.fail("tcp establish retry limit exceeded")
+      return 0, errors.New("tcp establish retry limit exceeded")
     }
 
     // Send the request.
     _, err = c.conn.Write(reqbytes)
     if err != nil {
-      c.closeConn("writing request: " + err.Error())
+      c.fail("writing request: " + err.Error())
       continue
     }
     time.Sleep(500 * time.Millisecond)
     c.conn.SetDeadline(time.Now().Add(connTimeout))
     n, err := c.conn.Read(rxBuf)
     if n == 0 && err != nil {
-      c.closeConn("reading response: " + err.Error())
+      c.fail("reading response: " + err.Error())
       continue
     } else if n == 0 {
-      c.closeConn("no response")
+      c.fail("no response")
       continue
     }
+    for readAll && n < len(rxBuf) && !responseComplete(rxBuf[:n]) {
+      m, err := c.conn.Read(rxBuf[n:])
+      n += m
+      if m == 0 || err != nil {
+        break
+      }
+    }
     c.logger.Debug("got HTTP response!")
     c.closeConn("done")
-    return string(rxBuf[:n]), nil
+    return n, nil
   }
 }
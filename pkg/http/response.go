@@ -0,0 +1,55 @@
+package http
+
+import (
+  "bytes"
+  "errors"
+  "strconv"
+)
+
+var (
+  errIncompleteHeader = errors.New("http: incomplete response header")
+  errMalformedStatus  = errors.New("http: malformed status line")
+)
+
+// splitResponse separates a raw HTTP/1.x response into its status code and
+// body. contentLength is -1 if the server did not send a Content-Length.
+func splitResponse(raw []byte) (status int, body []byte, contentLength int, err error) {
+  end := bytes.Index(raw, []byte("\r\n\r\n"))
+  if end < 0 {
+    return 0, nil, -1, errIncompleteHeader
+  }
+  header := raw[:end]
+  body = raw[end+4:]
+
+  statusLine, rest, _ := bytes.Cut(header, []byte("\r\n"))
+  fields := bytes.Fields(statusLine)
+  if len(fields) < 2 || !bytes.HasPrefix(fields[0], []byte("HTTP/")) {
+    return 0, nil, -1, errMalformedStatus
+  }
+  status, err = strconv.Atoi(string(fields[1]))
+  if err != nil {
+    return 0, nil, -1, errMalformedStatus
+  }
+
+  contentLength = -1
+  for len(rest) > 0 {
+    var line []byte
+    line, rest, _ = bytes.Cut(rest, []byte("\r\n"))
+    key, value, ok := bytes.Cut(line, []byte(":"))
+    if !ok || !bytes.EqualFold(bytes.TrimSpace(key), []byte("Content-Length")) {
+      continue
+    }
+    contentLength, err = strconv.Atoi(string(bytes.TrimSpace(value)))
+    if err != nil {
+      return status, body, -1, errors.New("http: invalid Content-Length")
+    }
+  }
+  return status, body, contentLength, nil
+}
+
+// responseComplete reports whether raw holds a full response, that is the
+// whole header and as many body bytes as announced by Content-Length.
+func responseComplete(raw []byte) bool {
+  _, body, contentLength, err := splitResponse(raw)
+  return err == nil && contentLength >= 0 && len(body) >= contentLength
+}
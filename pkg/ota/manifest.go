@@ -0,0 +1,73 @@
+package ota
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Manifest describes a firmware image offered by the server. It is served as
+// plain text, one "key: value" pair per line:
+//
+//	version: 1.2.0
+//	size: 184320
+//	sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	path: /orangeclock/firmware/1.2.0.bin
+//	signature: <hex encoded ed25519 signature of the sha256 sum, optional>
+type Manifest struct {
+	Version   string
+	Size      int64
+	SHA256    [32]byte
+	Path      string
+	Signature []byte
+}
+
+// ParseManifest parses the manifest text served by the update server.
+func ParseManifest(text string) (*Manifest, error) {
+	var m Manifest
+	var hasHash bool
+	for _, line := range strings.Split(text, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "version":
+			m.Version = value
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size <= 0 {
+				return nil, errors.New("ota: invalid manifest size")
+			}
+			m.Size = size
+		case "sha256":
+			sum, err := hex.DecodeString(value)
+			if err != nil || len(sum) != len(m.SHA256) {
+				return nil, errors.New("ota: invalid manifest sha256")
+			}
+			copy(m.SHA256[:], sum)
+			hasHash = true
+		case "path":
+			m.Path = value
+		case "signature":
+			sig, err := hex.DecodeString(value)
+			if err != nil {
+				return nil, errors.New("ota: invalid manifest signature")
+			}
+			m.Signature = sig
+		}
+	}
+	switch {
+	case m.Version == "":
+		return nil, errors.New("ota: manifest without version")
+	case m.Size == 0:
+		return nil, errors.New("ota: manifest without size")
+	case !hasHash:
+		return nil, errors.New("ota: manifest without sha256")
+	case m.Path == "":
+		return nil, errors.New("ota: manifest without path")
+	}
+	return &m, nil
+}
@@ -0,0 +1,108 @@
+// Package ota checks the update server for newer firmware.
+//
+// Only the check is implemented so far: installing an image needs a second
+// stage bootloader that boots the new flash slot and rolls back a broken
+// image, and that is not part of this repository yet.
+package ota
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+var errBadSignature = errors.New("ota: manifest signature verification failed")
+
+// Fetcher is the part of the HTTP client used to get the manifest.
+type Fetcher interface {
+	NewBodyRequest(path string, buf []byte) ([]byte, error)
+}
+
+type Config struct {
+	Client Fetcher
+	// Path of the manifest on the update server.
+	ManifestPath string
+	// Version of the running firmware, dotted numbers like "1.2.0". Only newer
+	// versions are offered, none if it is not a version number, e.g. "dev".
+	CurrentVersion string
+	// PublicKey verifies manifest signatures. If set, unsigned manifests are rejected.
+	PublicKey ed25519.PublicKey
+	Logger    *slog.Logger
+}
+
+type Updater struct {
+	cfg    Config
+	logger *slog.Logger
+	buf    [1024]byte // manifest plus HTTP response header
+}
+
+func New(cfg Config) (*Updater, error) {
+	if cfg.Client == nil {
+		return nil, errors.New("ota: client is required")
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Updater{cfg: cfg, logger: logger}, nil
+}
+
+// Check fetches the manifest and reports whether it offers a version newer
+// than the running one.
+func (u *Updater) Check() (*Manifest, bool, error) {
+	body, err := u.cfg.Client.NewBodyRequest(u.cfg.ManifestPath, u.buf[:])
+	if err != nil {
+		return nil, false, err
+	}
+	m, err := ParseManifest(string(body))
+	if err != nil {
+		return nil, false, err
+	}
+	if u.cfg.PublicKey != nil && !ed25519.Verify(u.cfg.PublicKey, m.SHA256[:], m.Signature) {
+		return nil, false, errBadSignature
+	}
+	newer := newerVersion(m.Version, u.cfg.CurrentVersion)
+	u.logger.Debug("ota:manifest", slog.String("version", m.Version), slog.Bool("newer", newer))
+	return m, newer, nil
+}
+
+// newerVersion reports whether v is a higher version than current. Both are
+// dotted numbers, anything else is never newer.
+func newerVersion(v, current string) bool {
+	a, okA := parseVersion(v)
+	b, okB := parseVersion(current)
+	if !okA || !okB {
+		return false
+	}
+	for i := 0; i < max(len(a), len(b)); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
+
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(v, "v")
+	if v == "" {
+		return nil, false
+	}
+	var parts []int
+	for _, s := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
@@ -0,0 +1,160 @@
+package ota
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const sum = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func manifest(version string, extra ...string) string {
+	lines := append([]string{
+		"version: " + version,
+		"size: 184320",
+		"sha256: " + sum,
+		"path: /orangeclock/firmware/" + version + ".bin",
+	}, extra...)
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func TestParseManifest(t *testing.T) {
+	m, err := ParseManifest("# comment\nVersion:  1.2.0 \n" + manifest("1.2.0")[len("version: 1.2.0\r\n"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != "1.2.0" || m.Size != 184320 || m.Path != "/orangeclock/firmware/1.2.0.bin" {
+		t.Errorf("manifest %+v", m)
+	}
+	if hex.EncodeToString(m.SHA256[:]) != sum || m.Signature != nil {
+		t.Errorf("sha256 %x, signature %x", m.SHA256, m.Signature)
+	}
+}
+
+func TestParseManifestErrors(t *testing.T) {
+	tests := []struct {
+		name, text string
+	}{
+		{"empty", ""},
+		{"no version", "size: 1\nsha256: " + sum + "\npath: /a"},
+		{"no size", "version: 1\nsha256: " + sum + "\npath: /a"},
+		{"no sha256", "version: 1\nsize: 1\npath: /a"},
+		{"no path", "version: 1\nsize: 1\nsha256: " + sum},
+		{"bad size", manifest("1", "size: -5")},
+		{"short sha256", manifest("1", "sha256: 9f86")},
+		{"bad signature", manifest("1", "signature: xyz")},
+	}
+	for _, tt := range tests {
+		if _, err := ParseManifest(tt.text); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		v, current string
+		newer      bool
+	}{
+		{"1.2.1", "1.2.0", true},
+		{"1.10.0", "1.9.9", true},
+		{"2", "1.99", true},
+		{"v1.3", "1.2.9", true},
+		{"1.2.0.1", "1.2", true},
+		{"1.2.0", "1.2", false},
+		{"1.2", "1.2.0", false},
+		{"1.2.0", "1.2.1", false},
+		{"1.2.0", "1.2.0", false},
+		{"1.2.0", "dev", false},
+		{"dev", "1.2.0", false},
+		{"1.2.0-rc1", "1.1.0", false},
+		{"1..2", "1.1", false},
+		{"", "1.0", false},
+		{"v", "0", false},
+	}
+	for _, tt := range tests {
+		if got := newerVersion(tt.v, tt.current); got != tt.newer {
+			t.Errorf("newerVersion(%q, %q) = %v, want %v", tt.v, tt.current, got, tt.newer)
+		}
+	}
+}
+
+type fetcher struct {
+	body string
+	err  error
+	path string
+}
+
+func (f *fetcher) NewBodyRequest(path string, buf []byte) ([]byte, error) {
+	f.path = path
+	if f.err != nil {
+		return nil, f.err
+	}
+	return buf[:copy(buf, f.body)], nil
+}
+
+func TestCheck(t *testing.T) {
+	f := &fetcher{body: manifest("1.3.0")}
+	u, err := New(Config{Client: f, ManifestPath: "/manifest", CurrentVersion: "1.2.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, newer, err := u.Check()
+	if err != nil || !newer || m.Version != "1.3.0" || f.path != "/manifest" {
+		t.Errorf("Check() = %+v, %v, %v from %q", m, newer, err, f.path)
+	}
+
+	f.body = manifest("1.1.0")
+	if _, newer, err := u.Check(); err != nil || newer {
+		t.Errorf("older version: newer %v, err %v", newer, err)
+	}
+
+	f.body = "garbage"
+	if _, _, err := u.Check(); err == nil {
+		t.Error("invalid manifest accepted")
+	}
+
+	f.err = errors.New("unreachable")
+	if _, _, err := u.Check(); err != f.err {
+		t.Errorf("error %v, want %v", err, f.err)
+	}
+
+	if _, err := New(Config{}); err == nil {
+		t.Error("New without client succeeded")
+	}
+}
+
+func TestCheckSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := hex.DecodeString(sum)
+	sig := hex.EncodeToString(ed25519.Sign(priv, hash))
+	f := &fetcher{}
+	u, err := New(Config{Client: f, CurrentVersion: "1.0", PublicKey: pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.body = manifest("1.1", "signature: "+sig)
+	if _, newer, err := u.Check(); err != nil || !newer {
+		t.Errorf("signed manifest: newer %v, err %v", newer, err)
+	}
+	f.body = manifest("1.1")
+	if _, _, err := u.Check(); err != errBadSignature {
+		t.Errorf("unsigned manifest: error %v, want %v", err, errBadSignature)
+	}
+	tampered := []byte(sig)
+	if tampered[0] == '0' {
+		tampered[0] = '1'
+	} else {
+		tampered[0] = '0'
+	}
+	f.body = manifest("1.1", "signature: "+string(tampered))
+	if _, _, err := u.Check(); err != errBadSignature {
+		t.Errorf("tampered signature: error %v, want %v", err, errBadSignature)
+	}
+}
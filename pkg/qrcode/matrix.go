@@ -0,0 +1,234 @@
+package qrcode
+
+// setFunction sets a module that belongs to a pattern or the format
+// information.
+func (c *Code) setFunction(x, y int, black bool) {
+	c.modules[y*c.size+x] = black
+	c.function[y*c.size+x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(c.size-4, 3)
+	c.drawFinder(3, c.size-4)
+
+	pos := alignment[c.version-1]
+	last := len(pos) - 1
+	for i, y := range pos {
+		for j, x := range pos {
+			// Skip the three corners with finder patterns.
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+
+	// Reserve the format area, the real bits follow once the mask is known.
+	c.drawFormat(Low, 0)
+	c.drawVersion()
+}
+
+// drawFinder draws a finder pattern with its separator around x, y.
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.size || yy >= c.size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormat writes both copies of the level and mask, protected by a
+// BCH(15,5) code.
+func (c *Code) drawFormat(level Level, mask int) {
+	data := formatBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.size-15+i, bit(i))
+	}
+	c.setFunction(8, c.size-8, true) // dark module
+}
+
+// drawVersion writes both copies of the version, protected by a BCH(18,6)
+// code, from version 7 on.
+func (c *Code) drawVersion() {
+	if c.version < 7 {
+		return
+	}
+	rem := c.version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	bits := c.version<<12 | rem
+	for i := 0; i < 18; i++ {
+		black := bits>>i&1 != 0
+		a, b := c.size-11+i%3, i/3
+		c.setFunction(a, b, black)
+		c.setFunction(b, a, black)
+	}
+}
+
+// drawCodewords places the bits in the zigzag of two module wide columns,
+// from the bottom right upwards, skipping the function modules. Modules left
+// over at the end are the remainder bits and stay white.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.size; vert++ {
+			y := vert
+			if upward {
+				y = c.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.function[y*c.size+x] || i >= len(data)*8 {
+					continue
+				}
+				c.modules[y*c.size+x] = data[i>>3]>>(7-i&7)&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by the mask pattern.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			i := y*c.size + x
+			if flip && !c.function[i] {
+				c.modules[i] = !c.modules[i]
+			}
+		}
+	}
+}
+
+// penalty scores the modules with the four rules of the standard, lower is
+// easier to scan.
+func (c *Code) penalty() int {
+	p := 0
+	at := func(x, y int, columns bool) bool {
+		if columns {
+			x, y = y, x
+		}
+		return c.modules[y*c.size+x]
+	}
+	// Runs of five or more modules of one color, and patterns looking like a
+	// finder, in rows and columns.
+	for _, columns := range [2]bool{false, true} {
+		for y := 0; y < c.size; y++ {
+			run := 0
+			var pattern uint16
+			for x := 0; x < c.size; x++ {
+				if x > 0 && at(x, y, columns) == at(x-1, y, columns) {
+					run++
+				} else {
+					if run >= 5 {
+						p += run - 2
+					}
+					run = 1
+				}
+				pattern = (pattern<<1 | b2u(at(x, y, columns))) & 0x7FF
+				if x >= 10 && (pattern == 0b10111010000 || pattern == 0b00001011101) {
+					p += 40
+				}
+			}
+			if run >= 5 {
+				p += run - 2
+			}
+		}
+	}
+	// 2x2 blocks of one color.
+	dark := 0
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			black := c.modules[y*c.size+x]
+			if black {
+				dark++
+			}
+			if x+1 < c.size && y+1 < c.size &&
+				black == c.modules[y*c.size+x+1] &&
+				black == c.modules[(y+1)*c.size+x] &&
+				black == c.modules[(y+1)*c.size+x+1] {
+				p += 3
+			}
+		}
+	}
+	// Balance of dark and light modules, 10 points per 5% off 50%.
+	total := c.size * c.size
+	p += abs(dark*20-total*10) / total * 10
+	return p
+}
+
+func b2u(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,236 @@
+// Package qrcode encodes text as QR code in byte mode, versions 1 to 10 (up
+// to 271 bytes at level Low), and draws it into any draw.Image. It keeps to
+// fixed tables and small allocations so it runs on TinyGo.
+package qrcode
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+const maxVersion = 10
+
+// QuietZone is the white border in modules the standard asks for.
+const QuietZone = 4
+
+// Level is the error correction level, higher levels survive more damage
+// but hold less data.
+type Level uint8
+
+const (
+	Low      Level = iota // recovers 7% of the codewords
+	Medium                // 15%
+	Quartile              // 25%
+	High                  // 30%
+)
+
+var ErrTooLong = errors.New("qrcode: data too long for version 10")
+
+// formatBits of the levels as written into the format information.
+var formatBits = [4]int{Low: 1, Medium: 0, Quartile: 3, High: 2}
+
+// Code is an encoded QR code, a square of Size() x Size() modules.
+type Code struct {
+	version  int
+	size     int
+	modules  []bool // true is black, row by row
+	function []bool // modules of patterns and format, never masked
+}
+
+// Encode returns the smallest code holding data at the given level.
+func Encode(data []byte, level Level) (*Code, error) {
+	if level > High {
+		level = High
+	}
+	version := 0
+	for v := 1; v <= maxVersion; v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= layouts[v-1][level].dataCodewords()*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	size := 17 + 4*version
+	c := &Code{
+		version:  version,
+		size:     size,
+		modules:  make([]bool, size*size),
+		function: make([]bool, size*size),
+	}
+	c.drawFunctionPatterns()
+	c.drawCodewords(addErrorCorrection(dataCodewords(data, version, level), layouts[version-1][level]))
+
+	// Use the mask with the lowest penalty.
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(level, mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask) // masks are their own inverse
+	}
+	c.applyMask(best)
+	c.drawFormat(level, best)
+	return c, nil
+}
+
+// Size returns the number of modules per side, without quiet zone.
+func (c *Code) Size() int {
+	return c.size
+}
+
+// Black reports whether the module at x, y is black.
+func (c *Code) Black(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.size || y >= c.size {
+		return false
+	}
+	return c.modules[y*c.size+x]
+}
+
+// Bounds returns the area Draw covers at x, y with the given module scale and
+// quiet zone in modules.
+func (c *Code) Bounds(x, y, scale, quiet int) image.Rectangle {
+	side := (c.size + 2*quiet) * scale
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// Draw paints the code with its top left corner, including the quiet zone,
+// at x, y. Each module is scale x scale pixels.
+func (c *Code) Draw(dst draw.Image, x, y, scale, quiet int) {
+	r := c.Bounds(x, y, scale, quiet)
+	draw.Draw(dst, r, image.White, image.Point{}, draw.Src)
+	ox, oy := x+quiet*scale, y+quiet*scale
+	for my := 0; my < c.size; my++ {
+		for mx := 0; mx < c.size; mx++ {
+			if !c.modules[my*c.size+mx] {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				for px := 0; px < scale; px++ {
+					dst.Set(ox+mx*scale+px, oy+my*scale+py, color.Black)
+				}
+			}
+		}
+	}
+}
+
+// dataCodewords packs data in byte mode and pads it to the capacity.
+func dataCodewords(data []byte, version int, level Level) []byte {
+	capacity := layouts[version-1][level].dataCodewords()
+	w := bitWriter{buf: make([]byte, 0, capacity)}
+	w.write(0b0100, 4) // byte mode
+	if version >= 10 {
+		w.write(len(data), 16)
+	} else {
+		w.write(len(data), 8)
+	}
+	for _, b := range data {
+		w.write(int(b), 8)
+	}
+	w.write(0, min(4, capacity*8-w.n)) // terminator
+	w.write(0, (8-w.n%8)%8)
+	for pad := 0; len(w.buf) < capacity; pad++ {
+		w.buf = append(w.buf, [2]byte{0xEC, 0x11}[pad%2])
+	}
+	return w.buf
+}
+
+type bitWriter struct {
+	buf []byte
+	n   int // bits written
+}
+
+func (w *bitWriter) write(v, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if v>>i&1 != 0 {
+			w.buf[len(w.buf)-1] |= 0x80 >> (w.n % 8)
+		}
+		w.n++
+	}
+}
+
+// addErrorCorrection splits data into blocks, appends the Reed-Solomon
+// codewords of each and interleaves the result.
+func addErrorCorrection(data []byte, l blockLayout) []byte {
+	divisor := rsDivisor(l.ec)
+	nblocks := l.blocks1 + l.blocks2
+	blocks := make([][]byte, nblocks)
+	ecs := make([][]byte, nblocks)
+	for i := range blocks {
+		n := l.data1
+		if i >= l.blocks1 {
+			n++
+		}
+		blocks[i], data = data[:n], data[n:]
+		ecs[i] = rsRemainder(blocks[i], divisor)
+	}
+
+	out := make([]byte, 0, l.dataCodewords()+nblocks*l.ec)
+	for i := 0; i <= l.data1; i++ {
+		for _, b := range blocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < l.ec; i++ {
+		for _, ec := range ecs {
+			out = append(out, ec[i])
+		}
+	}
+	return out
+}
+
+// rsDivisor returns the generator polynomial of the given degree, highest
+// coefficient first and the leading 1 left out.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+	return result
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMul(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
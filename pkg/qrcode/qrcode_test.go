@@ -0,0 +1,285 @@
+package qrcode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// Codewords by version (ISO/IEC 18004 table 1).
+var totalCodewords = [maxVersion]int{26, 44, 70, 100, 134, 172, 196, 242, 292, 346}
+
+func TestLayouts(t *testing.T) {
+	for v := 1; v <= maxVersion; v++ {
+		for level, l := range layouts[v-1] {
+			if n := l.dataCodewords() + (l.blocks1+l.blocks2)*l.ec; n != totalCodewords[v-1] {
+				t.Errorf("version %d level %d: %d codewords, want %d", v, level, n, totalCodewords[v-1])
+			}
+		}
+
+		c := &Code{version: v, size: 17 + 4*v}
+		c.modules = make([]bool, c.size*c.size)
+		c.function = make([]bool, c.size*c.size)
+		c.drawFunctionPatterns()
+		free := 0
+		for _, f := range c.function {
+			if !f {
+				free++
+			}
+		}
+		if rem := free - totalCodewords[v-1]*8; rem < 0 || rem > 7 {
+			t.Errorf("version %d: %d data modules for %d codewords", v, free, totalCodewords[v-1])
+		}
+	}
+}
+
+func TestReedSolomon(t *testing.T) {
+	// The "HELLO WORLD" 1-M example: data and error correction codewords.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("error correction %v, want %v", got, want)
+	}
+}
+
+func TestEncodeVersion(t *testing.T) {
+	tests := []struct {
+		n       int
+		level   Level
+		version int
+	}{
+		{0, Low, 1},
+		{17, Low, 1},
+		{18, Low, 2},
+		{14, Medium, 1},
+		{7, High, 1},
+		{8, High, 2},
+		{64, Low, 4}, // a bitcoin URI with amount
+		{271, Low, 10},
+		{119, High, 10},
+	}
+	for _, tt := range tests {
+		c, err := Encode(bytes.Repeat([]byte{'a'}, tt.n), tt.level)
+		if err != nil {
+			t.Errorf("%d bytes at level %d: %v", tt.n, tt.level, err)
+			continue
+		}
+		if c.version != tt.version || c.Size() != 17+4*tt.version {
+			t.Errorf("%d bytes at level %d: version %d size %d, want version %d", tt.n, tt.level, c.version, c.Size(), tt.version)
+		}
+	}
+	if _, err := Encode(make([]byte, 272), Low); err != ErrTooLong {
+		t.Errorf("272 bytes: error %v, want %v", err, ErrTooLong)
+	}
+	if _, err := Encode(make([]byte, 120), High); err != ErrTooLong {
+		t.Errorf("120 bytes at level High: error %v, want %v", err, ErrTooLong)
+	}
+}
+
+// readFormat returns the level and mask of both format copies after checking
+// that they agree and carry a valid BCH code.
+func readFormat(t *testing.T, c *Code) (Level, int) {
+	t.Helper()
+	read := func(at func(i int) (int, int)) int {
+		bits := 0
+		for i := 0; i < 15; i++ {
+			if c.Black(at(i)) {
+				bits |= 1 << i
+			}
+		}
+		return bits
+	}
+	first := read(func(i int) (int, int) {
+		switch {
+		case i <= 5:
+			return 8, i
+		case i <= 7:
+			return 8, i + 1
+		case i == 8:
+			return 7, 8
+		}
+		return 14 - i, 8
+	})
+	second := read(func(i int) (int, int) {
+		if i < 8 {
+			return c.size - 1 - i, 8
+		}
+		return 8, c.size - 15 + i
+	})
+	if first != second {
+		t.Fatalf("format copies differ: %015b and %015b", first, second)
+	}
+	bits := first ^ 0x5412
+	rem := bits
+	for i := 14; i >= 10; i-- {
+		if rem>>i&1 != 0 {
+			rem ^= 0x537 << (i - 10)
+		}
+	}
+	if rem != 0 {
+		t.Fatalf("format %015b fails its BCH check", first)
+	}
+	for level, f := range formatBits {
+		if f == bits>>13 {
+			return Level(level), bits >> 10 & 7
+		}
+	}
+	t.Fatalf("format %015b has no level", first)
+	return 0, 0
+}
+
+// readCodewords unmasks c and reads back the codewords in placement order.
+func readCodewords(c *Code, mask int) []byte {
+	c.applyMask(mask)
+	defer c.applyMask(mask)
+	var out []byte
+	i := 0
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.size; vert++ {
+			y := vert
+			if upward {
+				y = c.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.function[y*c.size+x] {
+					continue
+				}
+				if i%8 == 0 {
+					out = append(out, 0)
+				}
+				if c.Black(x, y) {
+					out[len(out)-1] |= 0x80 >> (i % 8)
+				}
+				i++
+			}
+		}
+	}
+	return out[:totalCodewords[c.version-1]]
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		text  string
+		level Level
+	}{
+		{"", Low},
+		{"bitcoin:bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", Medium},
+		{"https://mempool.space/block/000000000000000000026f1a2d2b9ab6fd0be33b25cd3b0e4dd5b0a3b5de6b1a", Quartile},
+		{strings.Repeat("orange clock ", 9), High},
+		{strings.Repeat("x", 271), Low},
+	}
+	for _, tt := range tests {
+		data := []byte(tt.text)
+		c, err := Encode(data, tt.level)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.text, err)
+		}
+		level, mask := readFormat(t, c)
+		if level != tt.level {
+			t.Errorf("%q: format level %d, want %d", tt.text, level, tt.level)
+		}
+
+		l := layouts[c.version-1][tt.level]
+		want := addErrorCorrection(dataCodewords(data, c.version, tt.level), l)
+		if got := readCodewords(c, mask); !bytes.Equal(got, want) {
+			t.Errorf("%q: codewords read back differ", tt.text)
+		}
+
+		// The first data codeword of block one carries the byte mode.
+		if got := want[0] >> 4; got != 0b0100 {
+			t.Errorf("%q: mode %04b, want byte mode", tt.text, got)
+		}
+		if c.version >= 7 {
+			checkVersion(t, c)
+		}
+	}
+}
+
+// checkVersion compares both version copies against the BCH(18,6) code.
+func checkVersion(t *testing.T, c *Code) {
+	t.Helper()
+	var a, b int
+	for i := 0; i < 18; i++ {
+		if c.Black(c.size-11+i%3, i/3) {
+			a |= 1 << i
+		}
+		if c.Black(i/3, c.size-11+i%3) {
+			b |= 1 << i
+		}
+	}
+	if a != b || a>>12 != c.version {
+		t.Fatalf("version copies %018b and %018b, want version %d", a, b, c.version)
+	}
+	rem := a
+	for i := 17; i >= 12; i-- {
+		if rem>>i&1 != 0 {
+			rem ^= 0x1F25 << (i - 12)
+		}
+	}
+	if rem != 0 {
+		t.Errorf("version %018b fails its BCH check", a)
+	}
+}
+
+func TestFinders(t *testing.T) {
+	c, err := Encode([]byte("finder"), Low)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []image.Point{{0, 0}, {c.size - 7, 0}, {0, c.size - 7}} {
+		for y := -1; y <= 7; y++ {
+			for x := -1; x <= 7; x++ {
+				d := max(abs(x-3), abs(y-3))
+				want := d != 2 && d != 4
+				if c.Black(o.X+x, o.Y+y) != want {
+					t.Fatalf("finder at %v: module %d,%d is %v", o, x, y, !want)
+				}
+			}
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	c, err := Encode([]byte("draw"), Low)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const scale = 3
+	r := c.Bounds(5, 7, scale, QuietZone)
+	if r.Dx() != (c.size+2*QuietZone)*scale || r.Min != image.Pt(5, 7) {
+		t.Fatalf("bounds %v", r)
+	}
+	img := image.NewGray(image.Rect(0, 0, r.Max.X+5, r.Max.Y+5))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80 // neither white nor black
+	}
+	c.Draw(img, 5, 7, scale, QuietZone)
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			got := img.GrayAt(x, y).Y
+			if !image.Pt(x, y).In(r) {
+				if got != 0x80 {
+					t.Fatalf("pixel %d,%d outside the bounds was drawn", x, y)
+				}
+				continue
+			}
+			mx := (x-r.Min.X)/scale - QuietZone
+			my := (y-r.Min.Y)/scale - QuietZone
+			want := color.Gray{0xff}
+			if c.Black(mx, my) {
+				want = color.Gray{0}
+			}
+			if got != want.Y {
+				t.Fatalf("pixel %d,%d is %#x, want %#x", x, y, got, want.Y)
+			}
+		}
+	}
+}
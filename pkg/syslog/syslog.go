@@ -0,0 +1,219 @@
+// Package syslog ships log records as RFC 5424 syslog datagrams over UDP.
+package syslog
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/soypat/seqs/stacks"
+	"orangeclock/pkg/wifi"
+)
+
+const (
+	facilityLocal0 = 16
+	// Records kept while the network is down, the oldest are dropped first.
+	queueSize = 32
+	// Records longer than this are truncated to stay within one datagram.
+	maxMessageLen = 480
+	flushInterval = 500 * time.Millisecond
+	arpRetryDelay = 10 * time.Second
+	// The Pico has no RTC, its clock starts in 1970. Earlier timestamps are
+	// sent as NILVALUE, the collector then uses its time of receipt.
+	minValidYear = 2024
+)
+
+type Config struct {
+	// Server is the syslog collector, usually on port 514.
+	Server netip.AddrPort
+	// LocalPort datagrams are sent from. Defaults to 514.
+	LocalPort uint16
+	Hostname  string
+	AppName   string
+	// Level is the minimum level sent, defaults to slog.LevelInfo.
+	Level slog.Leveler
+}
+
+// Handler is a slog.Handler sending records to a syslog server. Records are
+// only queued by Handle, a background goroutine sends them once a network is
+// attached. It never blocks the caller, so it is safe to use as logger of the
+// network stack itself.
+type Handler struct {
+	shared *shared
+	text   slog.Handler // Formats message and attributes of a record.
+}
+
+// shared is the state common to a Handler and those derived from it with
+// WithAttrs and WithGroup.
+type shared struct {
+	cfg    Config
+	mu     sync.Mutex
+	fmtBuf bytes.Buffer
+	queue  [queueSize][]byte
+	head   int
+	count  int
+	stack  *stacks.PortStack
+	router netip.Addr
+	done   chan struct{}
+}
+
+// NewHandler returns a Handler buffering records until Attach is called.
+// Close stops its background goroutine.
+func NewHandler(cfg Config) *Handler {
+	if cfg.LocalPort == 0 {
+		cfg.LocalPort = 514
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname = "-"
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = "-"
+	}
+	if cfg.Level == nil {
+		cfg.Level = slog.LevelInfo
+	}
+	s := &shared{cfg: cfg, done: make(chan struct{})}
+	h := &Handler{
+		shared: s,
+		text: slog.NewTextHandler(&s.fmtBuf, &slog.HandlerOptions{
+			Level: slog.Level(-128), // Filtering is done in Enabled.
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+					return slog.Attr{} // Part of the syslog header.
+				}
+				return a
+			},
+		}),
+	}
+	go s.run()
+	return h
+}
+
+// Attach starts sending queued and future records over stack. Datagrams go
+// to the hardware address of router.
+func (h *Handler) Attach(stack *stacks.PortStack, router netip.Addr) {
+	h.shared.mu.Lock()
+	defer h.shared.mu.Unlock()
+	h.shared.stack = stack
+	h.shared.router = router
+}
+
+// Close stops sending. Records still queued are discarded.
+func (h *Handler) Close() {
+	close(h.shared.done)
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.shared.cfg.Level.Level()
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	s := h.shared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fmtBuf.Reset()
+	if err := h.text.Handle(ctx, r); err != nil {
+		return err
+	}
+	msg := bytes.TrimSuffix(s.fmtBuf.Bytes(), []byte("\n"))
+	if len(msg) > maxMessageLen {
+		msg = msg[:maxMessageLen]
+	}
+
+	// RFC 5424: <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+	b := make([]byte, 0, 64+len(msg))
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(facilityLocal0*8+severity(r.Level)), 10)
+	b = append(b, ">1 "...)
+	if r.Time.Year() < minValidYear {
+		b = append(b, '-')
+	} else {
+		b = r.Time.UTC().AppendFormat(b, "2006-01-02T15:04:05.000000Z07:00")
+	}
+	b = append(b, ' ')
+	b = append(b, s.cfg.Hostname...)
+	b = append(b, ' ')
+	b = append(b, s.cfg.AppName...)
+	b = append(b, " - - - "...)
+	b = append(b, msg...)
+
+	if s.count == queueSize {
+		s.head = (s.head + 1) % queueSize // Drop the oldest record.
+		s.count--
+	}
+	s.queue[(s.head+s.count)%queueSize] = b
+	s.count++
+	return nil
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{shared: h.shared, text: h.text.WithAttrs(attrs)}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{shared: h.shared, text: h.text.WithGroup(name)}
+}
+
+// run sends queued records whenever a network is attached.
+func (s *shared) run() {
+	var nextARP time.Time
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-time.After(flushInterval):
+		}
+		s.mu.Lock()
+		stack, router := s.stack, s.router
+		s.mu.Unlock()
+		if stack == nil || !router.IsValid() {
+			continue
+		}
+		if time.Now().Before(nextARP) {
+			continue
+		}
+		routerhw, err := wifi.ResolveHardwareAddr(stack, router) // Cached by package wifi.
+		if err != nil {
+			nextARP = time.Now().Add(arpRetryDelay)
+			continue
+		}
+		for s.sendNext(stack, routerhw) {
+		}
+	}
+}
+
+// sendNext hands the oldest queued record to the NIC and reports whether it
+// did so. A record stays queued if the NIC cannot take it yet.
+func (s *shared) sendNext(stack *stacks.PortStack, routerhw [6]byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count == 0 {
+		return false
+	}
+	err := wifi.SendUDP(stack, routerhw, s.cfg.LocalPort, s.cfg.Server, s.queue[s.head])
+	if err != nil {
+		return false
+	}
+	s.queue[s.head] = nil
+	s.head = (s.head + 1) % queueSize
+	s.count--
+	return true
+}
+
+// severity maps slog levels to syslog severities.
+func severity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // Error
+	case level >= slog.LevelWarn:
+		return 4 // Warning
+	case level >= slog.LevelInfo:
+		return 6 // Informational
+	default:
+		return 7 // Debug
+	}
+}
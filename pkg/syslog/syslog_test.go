@@ -0,0 +1,35 @@
+package syslog
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleTimestamp(t *testing.T) {
+	tests := []struct {
+		time time.Time
+		want string
+	}{
+		{time.Time{}, "<134>1 - clock app - - - "},
+		{time.Unix(3600, 0), "<134>1 - clock app - - - "}, // clock not set yet
+		{time.Date(2026, 10, 19, 12, 30, 0, 5000, time.UTC), "<134>1 2026-10-19T12:30:00.000005Z clock app - - - "},
+	}
+	h := NewHandler(Config{Hostname: "clock", AppName: "app"})
+	defer h.Close()
+	for _, tt := range tests {
+		r := slog.NewRecord(tt.time, slog.LevelInfo, "hello", 0)
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		s := h.shared
+		s.mu.Lock()
+		got := string(s.queue[(s.head+s.count-1)%queueSize])
+		s.mu.Unlock()
+		if !strings.HasPrefix(got, tt.want) || !strings.HasSuffix(got, "msg=hello") {
+			t.Errorf("record at %v: %q, want prefix %q", tt.time, got, tt.want)
+		}
+	}
+}
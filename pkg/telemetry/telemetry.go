@@ -0,0 +1,169 @@
+// Package telemetry reports the health of a clock to the server.
+package telemetry
+
+import (
+	"encoding/hex"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Poster is the part of the HTTP client used to send reports.
+type Poster interface {
+	NewPostRequest(path, contentType string, body []byte) error
+}
+
+// RefreshCounter is implemented by the display driver. Its counts start at
+// zero with every new driver, see Stats.AddRefreshes.
+type RefreshCounter interface {
+	RefreshCounts() (full, partial uint32)
+}
+
+// Stats counts the outcome of the main loop's work. It is safe for concurrent use.
+type Stats struct {
+	mu          sync.Mutex
+	fetchOK     uint32
+	fetchFailed uint32
+	lastErr     string
+	// Refreshes of displays that were replaced.
+	fullRefreshes    uint32
+	partialRefreshes uint32
+}
+
+func (s *Stats) FetchSucceeded() {
+	s.mu.Lock()
+	s.fetchOK++
+	s.mu.Unlock()
+}
+
+// FetchFailed counts a failed data request and records err as the last error.
+func (s *Stats) FetchFailed(err error) {
+	s.mu.Lock()
+	s.fetchFailed++
+	s.lastErr = err.Error()
+	s.mu.Unlock()
+}
+
+// Error records err as the last error without counting a failed fetch.
+func (s *Stats) Error(err error) {
+	s.mu.Lock()
+	s.lastErr = err.Error()
+	s.mu.Unlock()
+}
+
+// AddRefreshes keeps the refresh counts of a display driver that is about to
+// be replaced, so the reports cover the whole uptime.
+func (s *Stats) AddRefreshes(full, partial uint32) {
+	s.mu.Lock()
+	s.fullRefreshes += full
+	s.partialRefreshes += partial
+	s.mu.Unlock()
+}
+
+// LastError returns the most recent error, empty if there was none.
+func (s *Stats) LastError() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// Report is a single health report.
+type Report struct {
+	DeviceID         string
+	Version          string
+	Uptime           time.Duration
+	FetchOK          uint32
+	FetchFailed      uint32
+	FullRefreshes    uint32
+	PartialRefreshes uint32
+	FreeHeap         uint64
+	LastError        string
+}
+
+// AppendJSON appends r as JSON object to b. The RSSI is always null, the
+// cyw43439 driver does not expose it.
+func (r *Report) AppendJSON(b []byte) []byte {
+	b = append(b, `{"device_id":`...)
+	b = appendJSONString(b, r.DeviceID)
+	b = append(b, `,"version":`...)
+	b = appendJSONString(b, r.Version)
+	b = append(b, `,"uptime_s":`...)
+	b = strconv.AppendInt(b, int64(r.Uptime/time.Second), 10)
+	b = append(b, `,"rssi":null,"fetch_ok":`...)
+	b = strconv.AppendUint(b, uint64(r.FetchOK), 10)
+	b = append(b, `,"fetch_failed":`...)
+	b = strconv.AppendUint(b, uint64(r.FetchFailed), 10)
+	b = append(b, `,"full_refreshes":`...)
+	b = strconv.AppendUint(b, uint64(r.FullRefreshes), 10)
+	b = append(b, `,"partial_refreshes":`...)
+	b = strconv.AppendUint(b, uint64(r.PartialRefreshes), 10)
+	b = append(b, `,"free_heap":`...)
+	b = strconv.AppendUint(b, r.FreeHeap, 10)
+	b = append(b, `,"last_error":`...)
+	b = appendJSONString(b, r.LastError)
+	return append(b, '}')
+}
+
+// Heartbeat sends reports built from the device's counters.
+type Heartbeat struct {
+	Client  Poster
+	Path    string
+	Version string
+	Display RefreshCounter
+	Stats   *Stats
+	// Boot is the time the device started, for the uptime.
+	Boot     time.Time
+	deviceID string
+}
+
+// SetDeviceID derives the device ID from the hardware address of the NIC.
+func (h *Heartbeat) SetDeviceID(mac [6]byte) {
+	h.deviceID = hex.EncodeToString(mac[:])
+}
+
+// Report collects the current values.
+func (h *Heartbeat) Report() Report {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	r := Report{
+		DeviceID: h.deviceID,
+		Version:  h.Version,
+		Uptime:   time.Since(h.Boot),
+		FreeHeap: mem.HeapSys - mem.HeapInuse,
+	}
+	if h.Display != nil {
+		r.FullRefreshes, r.PartialRefreshes = h.Display.RefreshCounts()
+	}
+	if h.Stats != nil {
+		h.Stats.mu.Lock()
+		r.FetchOK, r.FetchFailed, r.LastError = h.Stats.fetchOK, h.Stats.fetchFailed, h.Stats.lastErr
+		r.FullRefreshes += h.Stats.fullRefreshes
+		r.PartialRefreshes += h.Stats.partialRefreshes
+		h.Stats.mu.Unlock()
+	}
+	return r
+}
+
+// Send posts the current report to the server.
+func (h *Heartbeat) Send() error {
+	r := h.Report()
+	return h.Client.NewPostRequest(h.Path, "application/json", r.AppendJSON(nil))
+}
+
+func appendJSONString(b []byte, s string) []byte {
+	const hexDigits = "0123456789abcdef"
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < 0x20:
+			b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+		default:
+			b = append(b, c)
+		}
+	}
+	return append(b, '"')
+}
@@ -0,0 +1,122 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAppendJSON(t *testing.T) {
+	r := Report{
+		DeviceID:         "28cdc1000001",
+		Version:          "v1.2.0",
+		Uptime:           90*time.Minute + 999*time.Millisecond,
+		FetchOK:          12,
+		FetchFailed:      3,
+		FullRefreshes:    4,
+		PartialRefreshes: 4000000000,
+		FreeHeap:         1 << 40,
+		LastError:        "read \"block\":\n\ttimeout \\ retry\x01 – °C",
+	}
+	b := r.AppendJSON([]byte("prefix"))
+	if string(b[:6]) != "prefix" {
+		t.Fatalf("prefix overwritten: %s", b)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(b[6:], &got); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b[6:], err)
+	}
+	want := map[string]any{
+		"device_id":         r.DeviceID,
+		"version":           r.Version,
+		"uptime_s":          float64(5400),
+		"rssi":              nil,
+		"fetch_ok":          float64(12),
+		"fetch_failed":      float64(3),
+		"full_refreshes":    float64(4),
+		"partial_refreshes": float64(4000000000),
+		"free_heap":         float64(1 << 40),
+		"last_error":        r.LastError,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestAppendJSONEmpty(t *testing.T) {
+	var r Report
+	var got map[string]any
+	if err := json.Unmarshal(r.AppendJSON(nil), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["device_id"] != "" || got["last_error"] != "" {
+		t.Errorf("empty strings encoded as %v and %v", got["device_id"], got["last_error"])
+	}
+}
+
+type counter struct{ full, partial uint32 }
+
+func (c counter) RefreshCounts() (uint32, uint32) { return c.full, c.partial }
+
+type poster struct {
+	path, contentType string
+	body              []byte
+}
+
+func (p *poster) NewPostRequest(path, contentType string, body []byte) error {
+	p.path, p.contentType, p.body = path, contentType, body
+	return nil
+}
+
+func TestHeartbeat(t *testing.T) {
+	stats := new(Stats)
+	stats.FetchSucceeded()
+	stats.FetchSucceeded()
+	stats.FetchFailed(errors.New("first"))
+	stats.Error(errors.New("display: busy timeout"))
+	stats.AddRefreshes(2, 30) // a display replaced after a fault
+	if stats.LastError() != "display: busy timeout" {
+		t.Errorf("last error %q", stats.LastError())
+	}
+
+	p := new(poster)
+	h := &Heartbeat{
+		Client:  p,
+		Path:    "/api/telemetry",
+		Version: "dev",
+		Display: counter{1, 5},
+		Stats:   stats,
+		Boot:    time.Now().Add(-time.Hour),
+	}
+	h.SetDeviceID([6]byte{0x28, 0xcd, 0xc1, 0x00, 0x00, 0x01})
+	r := h.Report()
+	if r.DeviceID != "28cdc1000001" {
+		t.Errorf("device ID %q", r.DeviceID)
+	}
+	if r.FetchOK != 2 || r.FetchFailed != 1 {
+		t.Errorf("fetches %d ok, %d failed, want 2 and 1", r.FetchOK, r.FetchFailed)
+	}
+	if r.FullRefreshes != 3 || r.PartialRefreshes != 35 {
+		t.Errorf("refreshes %d full, %d partial, want 3 and 35", r.FullRefreshes, r.PartialRefreshes)
+	}
+	if r.Uptime < time.Hour {
+		t.Errorf("uptime %v", r.Uptime)
+	}
+
+	if err := h.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if p.path != "/api/telemetry" || p.contentType != "application/json" || !json.Valid(p.body) {
+		t.Errorf("posted %q to %s as %s", p.body, p.path, p.contentType)
+	}
+}
+
+func TestHeartbeatWithoutDisplay(t *testing.T) {
+	h := &Heartbeat{Boot: time.Now()}
+	r := h.Report() // no display and no stats yet
+	if r.FullRefreshes != 0 || r.FetchOK != 0 {
+		t.Errorf("report %+v", r)
+	}
+}
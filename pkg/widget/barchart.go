@@ -0,0 +1,72 @@
+package widget
+
+import (
+	"image"
+	"orangeclock/pkg/canvas"
+)
+
+// Bar is one bar of a BarChart.
+type Bar struct {
+	Label string
+	Value float64
+}
+
+// BarChart draws Bars as filled columns from left to right, scaled so the
+// largest value fills the height. Labels are below the bars, a label wider
+// than its bar is left out rather than cut to a misleading text.
+type BarChart struct {
+	Bounds image.Rectangle
+	Bars   []Bar
+	// ValueLabels shows each value above its bar.
+	ValueLabels bool
+	// Pixels between two bars.
+	Gap int
+	// Font of the labels, canvas.FontSmall if nil.
+	Font *canvas.Font
+}
+
+func (b *BarChart) Draw(c *canvas.Canvas) {
+	erase(c, b.Bounds)
+	if len(b.Bars) == 0 {
+		return
+	}
+	f := fontOrSmall(b.Font)
+	area := b.Bounds
+	area.Max.Y -= f.Height + 1
+	if b.ValueLabels {
+		area.Min.Y += f.Height + 1
+	}
+	barWidth := (area.Dx() - b.Gap*(len(b.Bars)-1)) / len(b.Bars)
+	if barWidth < 1 || area.Dy() < 1 {
+		return
+	}
+
+	var hi float64
+	for _, bar := range b.Bars {
+		hi = max(hi, bar.Value)
+	}
+	for i, bar := range b.Bars {
+		x := area.Min.X + i*(barWidth+b.Gap)
+		column := image.Rect(x, b.Bounds.Min.Y, x+barWidth, b.Bounds.Max.Y)
+		h := 0
+		if bar.Value > 0 {
+			h = scale(bar.Value, 0, hi, area.Dy()) + 1
+		}
+		top := area.Max.Y - h
+		c.FillRect(image.Rect(x, top, x+barWidth, area.Max.Y), canvas.Black)
+
+		label(c, column, area.Max.Y+1, f, bar.Label)
+		if b.ValueLabels {
+			label(c, column, top-f.Height-1, f, formatValue(bar.Value))
+		}
+	}
+}
+
+// label draws text centered in the column r at y, nothing if it is wider.
+func label(c *canvas.Canvas, r image.Rectangle, y int, f *canvas.Font, text string) {
+	w := f.TextWidth(text)
+	if w > r.Dx() {
+		return
+	}
+	c.Text(r.Min.X+(r.Dx()-w)/2, y, f, text)
+}
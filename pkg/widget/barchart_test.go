@@ -0,0 +1,104 @@
+package widget
+
+import (
+	"image"
+	"orangeclock/pkg/canvas"
+	"testing"
+)
+
+func TestBarChart(t *testing.T) {
+	bounds := image.Rect(228, 72, 294, 128)
+	tests := []struct {
+		name string
+		bars []Bar
+	}{
+		{"none", nil},
+		{"fees", []Bar{{"LO", 541}, {"MID", 700}, {"HI", 800}}},
+		{"zero", []Bar{{"LO", 0}, {"MID", 0}}},
+		{"many", []Bar{{"1", 5}, {"2", 4}, {"3", 3}, {"4", 2}, {"5", 1}, {"6", 0.5}}},
+	}
+	for _, tt := range tests {
+		_, points := drawn(t, &BarChart{Bounds: bounds, Bars: tt.bars, ValueLabels: true, Gap: 2})
+		if p, ok := outside(points, bounds); ok {
+			t.Errorf("%s: pixel %v outside %v", tt.name, p, bounds)
+		}
+	}
+}
+
+func TestBarChartLabels(t *testing.T) {
+	f := canvas.FontSmall
+	// Three bars of 16 pixels: "541" and "HIGH" do not fit and are left out,
+	// "LO" and "80" do.
+	bounds := image.Rect(0, 0, 52, 60)
+	chart := &BarChart{
+		Bounds:      bounds,
+		Bars:        []Bar{{"HIGH", 541}, {"LO", 80}, {"MID", 90}},
+		ValueLabels: true,
+		Gap:         2,
+	}
+	c, _ := drawn(t, chart)
+	barWidth := (bounds.Dx() - 2*chart.Gap) / 3
+	labelRow := func(x, y int) bool {
+		for dy := 0; dy < f.Height; dy++ {
+			for dx := 0; dx < barWidth; dx++ {
+				if c.At(x+dx, y+dy) == canvas.Black {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	bottom := bounds.Max.Y - f.Height
+	if labelRow(0, bottom) {
+		t.Error("label wider than its bar was drawn")
+	}
+	if !labelRow(barWidth+chart.Gap, bottom) {
+		t.Error("label fitting its bar is missing")
+	}
+	if labelRow(0, bounds.Min.Y) {
+		t.Error("value label wider than its bar was drawn")
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	bounds := image.Rect(9, 91, 224, 98)
+	tests := []struct {
+		value, max float64
+		filled     bool
+	}{
+		{0, 210000, false},
+		{1, 210000, true}, // a new epoch shows a pixel
+		{105000, 210000, true},
+		{300000, 210000, true},
+		{5, 0, false},
+	}
+	for _, tt := range tests {
+		p := &ProgressBar{Bounds: bounds, Value: tt.value, Max: tt.max, Ticks: 4, Label: Percent(tt.value, tt.max)}
+		c, points := drawn(t, p)
+		if pt, ok := outside(points, bounds); ok {
+			t.Errorf("%v of %v: pixel %v outside %v", tt.value, tt.max, pt, bounds)
+		}
+		// The first pixel inside the outline, below the tick marks.
+		inside := c.At(bounds.Min.X+1, bounds.Min.Y+bounds.Dy()/2) == canvas.Black
+		if inside != tt.filled {
+			t.Errorf("%v of %v: filled %v, want %v", tt.value, tt.max, inside, tt.filled)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		value, total float64
+		want         string
+	}{
+		{209924, 1050000, "20.0%"},
+		{0, 100, "0.0%"},
+		{1, 0, "0.0%"},
+		{2016, 2016, "100.0%"},
+	}
+	for _, tt := range tests {
+		if got := Percent(tt.value, tt.total); got != tt.want {
+			t.Errorf("Percent(%v, %v) = %q, want %q", tt.value, tt.total, got, tt.want)
+		}
+	}
+}
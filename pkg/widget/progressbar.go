@@ -0,0 +1,61 @@
+package widget
+
+import (
+	"image"
+	"orangeclock/pkg/canvas"
+	"strconv"
+)
+
+// ProgressBar fills an outlined bar in proportion to Value of Max. Any
+// progress above zero shows at least one pixel, so a new epoch is told apart
+// from none.
+type ProgressBar struct {
+	Bounds     image.Rectangle
+	Value, Max float64
+	// Ticks divides the bar into this many sections with marks at the edges.
+	Ticks int
+	// Label is drawn right of the bar, e.g. Percent(Value, Max).
+	Label string
+	// Font of the label, canvas.FontSmall if nil.
+	Font *canvas.Font
+}
+
+func (p *ProgressBar) Draw(c *canvas.Canvas) {
+	erase(c, p.Bounds)
+	bar := p.Bounds
+	if p.Label != "" {
+		f := fontOrSmall(p.Font)
+		width := f.TextWidth(p.Label)
+		bar.Max.X -= width + f.Advance()
+		c.Text(p.Bounds.Max.X-width, bar.Min.Y+(bar.Dy()-f.Height)/2, f, p.Label)
+	}
+	if bar.Dx() < 3 || bar.Dy() < 3 {
+		return
+	}
+	c.Rect(bar, canvas.Black)
+
+	inner := bar.Inset(1)
+	var filled int
+	if p.Max > 0 && p.Value > 0 {
+		filled = max(int(min(p.Value/p.Max, 1)*float64(inner.Dx())), 1)
+	}
+	c.FillRect(image.Rect(inner.Min.X, inner.Min.Y, inner.Min.X+filled, inner.Max.Y), canvas.Black)
+
+	// Tick marks reach a third into the bar from the top and bottom edge and
+	// stay visible on the filled part.
+	tick := max(inner.Dy()/3, 1)
+	for i := 1; i < p.Ticks; i++ {
+		x := inner.Min.X + i*inner.Dx()/p.Ticks
+		c.Invert(image.Rect(x, inner.Min.Y, x+1, inner.Min.Y+tick))
+		c.Invert(image.Rect(x, inner.Max.Y-tick, x+1, inner.Max.Y))
+	}
+}
+
+// Percent formats value of total as a percentage with one decimal, e.g.
+// "19.9%".
+func Percent(value, total float64) string {
+	if total <= 0 {
+		return "0.0%"
+	}
+	return strconv.FormatFloat(value/total*100, 'f', 1, 64) + "%"
+}
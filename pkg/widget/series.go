@@ -0,0 +1,46 @@
+package widget
+
+// Series keeps the latest samples of a value, e.g. the price of the last 24
+// hours, dropping the oldest once it is full.
+type Series struct {
+	values []float64
+	start  int
+	n      int
+}
+
+// NewSeries returns an empty series holding up to capacity samples.
+func NewSeries(capacity int) *Series {
+	return &Series{values: make([]float64, capacity)}
+}
+
+// Add appends v, replacing the oldest sample if the series is full.
+func (s *Series) Add(v float64) {
+	if len(s.values) == 0 {
+		return
+	}
+	if s.n < len(s.values) {
+		s.values[(s.start+s.n)%len(s.values)] = v
+		s.n++
+		return
+	}
+	s.values[s.start] = v
+	s.start = (s.start + 1) % len(s.values)
+}
+
+// Len returns the number of samples.
+func (s *Series) Len() int {
+	return s.n
+}
+
+// Values appends the samples to dst, oldest first.
+func (s *Series) Values(dst []float64) []float64 {
+	for i := 0; i < s.n; i++ {
+		dst = append(dst, s.values[(s.start+i)%len(s.values)])
+	}
+	return dst
+}
+
+// Reset removes all samples.
+func (s *Series) Reset() {
+	s.start, s.n = 0, 0
+}
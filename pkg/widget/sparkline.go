@@ -0,0 +1,57 @@
+package widget
+
+import (
+	"image"
+	"orangeclock/pkg/canvas"
+)
+
+// Sparkline plots Values as a line from left (oldest) to right, scaled to
+// their minimum and maximum.
+type Sparkline struct {
+	Bounds image.Rectangle
+	Values []float64
+	// Labels shows the maximum at the top and the minimum at the bottom of a
+	// column right of the plot.
+	Labels bool
+	// Font of the labels, canvas.FontSmall if nil.
+	Font *canvas.Font
+}
+
+func (s *Sparkline) Draw(c *canvas.Canvas) {
+	erase(c, s.Bounds)
+	if len(s.Values) == 0 {
+		return
+	}
+	lo, hi := s.Values[0], s.Values[0]
+	for _, v := range s.Values[1:] {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+
+	plot := s.Bounds
+	if s.Labels {
+		f := fontOrSmall(s.Font)
+		maxLabel, minLabel := formatValue(hi), formatValue(lo)
+		labelWidth := max(f.TextWidth(maxLabel), f.TextWidth(minLabel))
+		plot.Max.X -= labelWidth + f.Spacing + 1
+		c.Text(s.Bounds.Max.X-f.TextWidth(maxLabel), s.Bounds.Min.Y, f, maxLabel)
+		c.Text(s.Bounds.Max.X-f.TextWidth(minLabel), s.Bounds.Max.Y-f.Height, f, minLabel)
+	}
+	if plot.Dx() < 1 || plot.Dy() < 1 {
+		return
+	}
+
+	point := func(i int) (int, int) {
+		x := plot.Min.X
+		if len(s.Values) > 1 {
+			x += i * (plot.Dx() - 1) / (len(s.Values) - 1)
+		}
+		return x, plot.Max.Y - 1 - scale(s.Values[i], lo, hi, plot.Dy())
+	}
+	x0, y0 := point(0)
+	c.Set(x0, y0, canvas.Black)
+	for i := 1; i < len(s.Values); i++ {
+		x1, y1 := point(i)
+		c.Line(x0, y0, x1, y1, canvas.Black)
+		x0, y0 = x1, y1
+	}
+}
@@ -0,0 +1,112 @@
+package widget
+
+import (
+	"image"
+	"math"
+	"orangeclock/pkg/canvas"
+	"testing"
+	"time"
+)
+
+// The price chart of the clock.
+var chartBounds = image.Rect(210, 28, 294, 66)
+
+// drawn draws w on a landscape canvas of the display's size and returns the
+// black pixels. It fails the test if Draw does not return.
+func drawn(t *testing.T, w interface{ Draw(*canvas.Canvas) }) (*canvas.Canvas, []image.Point) {
+	t.Helper()
+	c := canvas.New(128, 296)
+	c.SetRotation(canvas.Rotation90)
+	done := make(chan struct{})
+	go func() {
+		w.Draw(c)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Draw did not return")
+	}
+	var points []image.Point
+	b := c.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if c.At(x, y) == canvas.Black {
+				points = append(points, image.Pt(x, y))
+			}
+		}
+	}
+	return c, points
+}
+
+// outside returns the first point not in r.
+func outside(points []image.Point, r image.Rectangle) (image.Point, bool) {
+	for _, p := range points {
+		if !p.In(r) {
+			return p, true
+		}
+	}
+	return image.Point{}, false
+}
+
+func TestSparkline(t *testing.T) {
+	wave := make([]float64, 144)
+	for i := range wave {
+		wave[i] = 64000 + 500*math.Sin(float64(i)/10)
+	}
+	tests := []struct {
+		name   string
+		values []float64
+	}{
+		{"none", nil},
+		{"one", []float64{64012}},
+		{"two", []float64{64012, 64100}},
+		{"two equal", []float64{64012, 64012}},
+		{"falling", []float64{3, 2, 1}},
+		{"144", wave},
+		{"144 equal", make([]float64, 144)},
+	}
+	for _, tt := range tests {
+		for _, labels := range []bool{false, true} {
+			_, points := drawn(t, &Sparkline{Bounds: chartBounds, Values: tt.values, Labels: labels})
+			if p, ok := outside(points, chartBounds); ok {
+				t.Errorf("%s, labels %v: pixel %v outside %v", tt.name, labels, p, chartBounds)
+			}
+			if len(tt.values) > 0 && len(points) == 0 {
+				t.Errorf("%s, labels %v: nothing drawn", tt.name, labels)
+			}
+		}
+	}
+}
+
+func TestSparklineEnds(t *testing.T) {
+	s := &Sparkline{Bounds: chartBounds, Values: []float64{1, 2}}
+	c, _ := drawn(t, s)
+	// The oldest and lowest value is bottom left, the newest and highest top
+	// right.
+	if c.At(chartBounds.Min.X, chartBounds.Max.Y-1) != canvas.Black {
+		t.Error("first value not at the bottom left")
+	}
+	if c.At(chartBounds.Max.X-1, chartBounds.Min.Y) != canvas.Black {
+		t.Error("last value not at the top right")
+	}
+}
+
+func TestSeries(t *testing.T) {
+	s := NewSeries(3)
+	if got := s.Values(nil); len(got) != 0 {
+		t.Errorf("empty series has values %v", got)
+	}
+	for _, v := range []float64{1, 2, 3, 4, 5} {
+		s.Add(v)
+	}
+	got := s.Values(nil)
+	if len(got) != 3 || got[0] != 3 || got[1] != 4 || got[2] != 5 {
+		t.Errorf("values %v, want [3 4 5]", got)
+	}
+	s.Reset()
+	if s.Len() != 0 {
+		t.Errorf("length %d after Reset", s.Len())
+	}
+	NewSeries(0).Add(1) // must not panic
+}
@@ -0,0 +1,42 @@
+// Package widget draws charts and gauges on a canvas. Widgets are plain
+// structs: set the area and data, then call Draw. They clear their area
+// first, so they can be redrawn in place.
+package widget
+
+import (
+	"image"
+	"orangeclock/pkg/canvas"
+	"strconv"
+)
+
+// fontOrSmall returns f, or canvas.FontSmall if f is nil.
+func fontOrSmall(f *canvas.Font) *canvas.Font {
+	if f == nil {
+		return canvas.FontSmall
+	}
+	return f
+}
+
+// formatValue formats v for a label, with decimals only for small values.
+func formatValue(v float64) string {
+	prec := 0
+	if v < 10 && v > -10 {
+		prec = 2
+	}
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+// scale maps v from [lo, hi] to a pixel offset in [0, size-1]. A flat range
+// maps to the middle.
+func scale(v, lo, hi float64, size int) int {
+	if hi <= lo {
+		return (size - 1) / 2
+	}
+	p := int((v-lo)/(hi-lo)*float64(size-1) + 0.5)
+	return min(max(p, 0), size-1)
+}
+
+// erase fills r with white.
+func erase(c *canvas.Canvas, r image.Rectangle) {
+	c.FillRect(r, canvas.White)
+}
@@ -0,0 +1,69 @@
+package wifi
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/soypat/seqs/stacks"
+)
+
+const (
+	// Entries are resolved again after this time, so a replaced router or a
+	// failover gateway is picked up without a restart.
+	arpCacheTTL  = 5 * time.Minute
+	arpCacheSize = 4
+)
+
+type arpEntry struct {
+	addr    netip.Addr
+	hw      [6]byte
+	expires time.Time
+}
+
+// arpCache is shared by all users of the package. The stack has a single ARP
+// client, so resolutions are serialized by mu as well.
+var arpCache struct {
+	mu      sync.Mutex
+	entries [arpCacheSize]arpEntry
+}
+
+// ResolveHardwareAddr obtains the hardware address of the given IP address.
+// Results are cached for arpCacheTTL, use InvalidateHardwareAddr when the
+// address stops working.
+func ResolveHardwareAddr(stack *stacks.PortStack, ip netip.Addr) ([6]byte, error) {
+	arpCache.mu.Lock()
+	defer arpCache.mu.Unlock()
+	now := time.Now()
+	slot := 0 // Entry of ip if present, the one expiring first otherwise.
+	for i, e := range arpCache.entries {
+		if e.addr == ip {
+			if now.Before(e.expires) {
+				return e.hw, nil
+			}
+			slot = i
+			break
+		}
+		if e.expires.Before(arpCache.entries[slot].expires) {
+			slot = i
+		}
+	}
+	hw, err := resolveARP(stack, ip)
+	if err != nil {
+		return hw, err
+	}
+	arpCache.entries[slot] = arpEntry{addr: ip, hw: hw, expires: now.Add(arpCacheTTL)}
+	return hw, nil
+}
+
+// InvalidateHardwareAddr drops the cached hardware address of ip. The next
+// ResolveHardwareAddr sends a new ARP request.
+func InvalidateHardwareAddr(ip netip.Addr) {
+	arpCache.mu.Lock()
+	defer arpCache.mu.Unlock()
+	for i := range arpCache.entries {
+		if arpCache.entries[i].addr == ip {
+			arpCache.entries[i] = arpEntry{}
+		}
+	}
+}
@@ -26,6 +26,8 @@ type SetupConfig struct {
 	UDPPorts uint16
 	// Number of TCP ports to open for the stack.
 	TCPPorts uint16
+	// Packet pump settings, zero values select the defaults.
+	NIC NICConfig
 }
 
 func SetupWithDHCP(cfg SetupConfig) (*stacks.DHCPClient, *stacks.PortStack, *cyw43439.Device, string, error) {
@@ -45,6 +47,9 @@ func SetupWithDHCP(cfg SetupConfig) (*stacks.DHCPClient, *stacks.PortStack, *cyw
 		}
 	}
 
+	// A previous setup's packet pump must not touch the device during Init.
+	stopActiveNIC()
+
 	dev := cyw43439.NewPicoWDevice()
 	wificfg := cyw43439.DefaultWifiConfig()
 	//wificfg.Logger = logger // Uncomment to see in depth info on wifi device functioning.
@@ -84,7 +89,11 @@ func SetupWithDHCP(cfg SetupConfig) (*stacks.DHCPClient, *stacks.PortStack, *cyw
 	dev.RecvEthHandle(stack.RecvEth)
 
 	// Begin asynchronous packet handling.
-	go nicLoop(dev, stack)
+	nicCfg := cfg.NIC
+	if nicCfg.Logger == nil {
+		nicCfg.Logger = logger
+	}
+	startNIC(dev, stack, nicCfg)
 
 	// Perform DHCP request.
 	dhcpClient := stacks.NewDHCPClient(stack, dhcp.DefaultClientPort)
@@ -134,8 +143,8 @@ func SetupWithDHCP(cfg SetupConfig) (*stacks.DHCPClient, *stacks.PortStack, *cyw
 	return dhcpClient, stack, dev, connectedSsid, nil
 }
 
-// ResolveHardwareAddr obtains the hardware address of the given IP address.
-func ResolveHardwareAddr(stack *stacks.PortStack, ip netip.Addr) ([6]byte, error) {
+// resolveARP sends an ARP request for ip and waits for the reply.
+func resolveARP(stack *stacks.PortStack, ip netip.Addr) ([6]byte, error) {
 	if !ip.IsValid() {
 		return [6]byte{}, errors.New("invalid ip")
 	}
@@ -207,6 +216,7 @@ func (r *Resolver) LookupNetIP(host string) ([]netip.Addr, error) {
 	}
 	done, rcode := r.dns.IsDone()
 	if !done && retries == 0 {
+		InvalidateHardwareAddr(r.dnsaddr) // The DNS server may have moved.
 		return nil, errors.New("dns lookup timed out")
 	} else if rcode != dns.RCodeSuccess {
 		return nil, errors.New("dns lookup failed:" + rcode.String())
@@ -247,78 +257,3 @@ func (r *Resolver) dnsConfig(name dns.Name) stacks.DNSResolveConfig {
 		EnableRecursion: true,
 	}
 }
-
-func nicLoop(dev *cyw43439.Device, Stack *stacks.PortStack) {
-	// Maximum number of packets to queue before sending them.
-	const (
-		queueSize                = 3
-		maxRetriesBeforeDropping = 3
-	)
-	var queue [queueSize][mtu]byte
-	var lenBuf [queueSize]int
-	var retries [queueSize]int
-	markSent := func(i int) {
-		queue[i] = [mtu]byte{} // Not really necessary.
-		lenBuf[i] = 0
-		retries[i] = 0
-	}
-	for {
-		stallRx := true
-		// Poll for incoming packets.
-		for i := 0; i < 1; i++ {
-			gotPacket, err := dev.TryPoll()
-			if err != nil {
-				println("poll error:", err.Error())
-			}
-			if !gotPacket {
-				break
-			}
-			stallRx = false
-		}
-
-		// Queue packets to be sent.
-		for i := range queue {
-			if retries[i] != 0 {
-				continue // Packet currently queued for retransmission.
-			}
-			var err error
-			buf := queue[i][:]
-			lenBuf[i], err = Stack.HandleEth(buf[:])
-			if err != nil {
-				println("stack error n(should be 0)=", lenBuf[i], "err=", err.Error())
-				lenBuf[i] = 0
-				continue
-			}
-			if lenBuf[i] == 0 {
-				break
-			}
-		}
-		stallTx := lenBuf == [queueSize]int{}
-		if stallTx {
-			if stallRx {
-				// Avoid busy waiting when both Rx and Tx stall.
-				time.Sleep(51 * time.Millisecond)
-			}
-			continue
-		}
-
-		// Send queued packets.
-		for i := range queue {
-			n := lenBuf[i]
-			if n <= 0 {
-				continue
-			}
-			err := dev.SendEth(queue[i][:n])
-			if err != nil {
-				// Queue packet for retransmission.
-				retries[i]++
-				if retries[i] > maxRetriesBeforeDropping {
-					markSent(i)
-					println("dropped outgoing packet:", err.Error())
-				}
-			} else {
-				markSent(i)
-			}
-		}
-	}
-}
@@ -0,0 +1,220 @@
+package wifi
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soypat/cyw43439"
+	"github.com/soypat/seqs/stacks"
+)
+
+// NICConfig configures the packet pump between the Wi-Fi chip and the stack.
+type NICConfig struct {
+	// Maximum number of packets to queue before sending them. Defaults to 3.
+	QueueSize int
+	// Send attempts before an outgoing packet is dropped. Defaults to 3.
+	MaxRetries int
+	// While nothing is received or sent the pump sleeps between polls. The
+	// sleep starts at MinIdle and doubles up to MaxIdle as long as the link
+	// stays idle. Short values favour latency, long values save power.
+	// Defaults to 5ms and 51ms.
+	MinIdle time.Duration
+	MaxIdle time.Duration
+	Logger  *slog.Logger
+}
+
+// NICStats are the counters of a NIC since it was started.
+type NICStats struct {
+	RxPackets   uint32
+	TxPackets   uint32
+	Drops       uint32 // Outgoing packets given up after MaxRetries.
+	Retransmits uint32
+	PollErrors  uint32
+	StackErrors uint32
+}
+
+// NIC moves packets between the cyw43439 device and the PortStack.
+type NIC struct {
+	dev   *cyw43439.Device
+	stack *stacks.PortStack
+	cfg   NICConfig
+
+	rx, tx, drops, retransmits, pollErrs, stackErrs atomic.Uint32
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var activeNIC struct {
+	mu  sync.Mutex
+	nic *NIC
+}
+
+// ActiveNIC returns the packet pump started by the last SetupWithDHCP, nil if
+// there is none running.
+func ActiveNIC() *NIC {
+	activeNIC.mu.Lock()
+	defer activeNIC.mu.Unlock()
+	return activeNIC.nic
+}
+
+func startNIC(dev *cyw43439.Device, stack *stacks.PortStack, cfg NICConfig) *NIC {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 3
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.MinIdle <= 0 {
+		cfg.MinIdle = 5 * time.Millisecond
+	}
+	if cfg.MaxIdle < cfg.MinIdle {
+		cfg.MaxIdle = max(51*time.Millisecond, cfg.MinIdle)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	n := &NIC{
+		dev:   dev,
+		stack: stack,
+		cfg:   cfg,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	activeNIC.mu.Lock()
+	activeNIC.nic = n
+	activeNIC.mu.Unlock()
+	go n.loop()
+	return n
+}
+
+func stopActiveNIC() {
+	activeNIC.mu.Lock()
+	n := activeNIC.nic
+	activeNIC.nic = nil
+	activeNIC.mu.Unlock()
+	if n != nil {
+		n.Stop()
+	}
+}
+
+// Stats returns a snapshot of the counters.
+func (n *NIC) Stats() NICStats {
+	return NICStats{
+		RxPackets:   n.rx.Load(),
+		TxPackets:   n.tx.Load(),
+		Drops:       n.drops.Load(),
+		Retransmits: n.retransmits.Load(),
+		PollErrors:  n.pollErrs.Load(),
+		StackErrors: n.stackErrs.Load(),
+	}
+}
+
+// Stop ends packet handling and waits for the pump to return. Queued packets
+// are discarded. The device and stack can be set up again afterwards.
+func (n *NIC) Stop() {
+	select {
+	case <-n.stop:
+	default:
+		close(n.stop)
+	}
+	<-n.done
+}
+
+func (n *NIC) loop() {
+	defer close(n.done)
+	size := n.cfg.QueueSize
+	queue := make([][mtu]byte, size)
+	lenBuf := make([]int, size)
+	retries := make([]int, size)
+	var rawFrame [mtu]byte
+	markSent := func(i int) {
+		lenBuf[i] = 0
+		retries[i] = 0
+	}
+	idle := n.cfg.MinIdle
+	for {
+		select {
+		case <-n.stop:
+			return
+		default:
+		}
+
+		stallRx := true
+		// Poll for incoming packets.
+		gotPacket, err := n.dev.TryPoll()
+		if err != nil {
+			n.pollErrs.Add(1)
+			n.cfg.Logger.Debug("nic:poll", slog.String("err", err.Error()))
+		}
+		if gotPacket {
+			n.rx.Add(1)
+			stallRx = false
+		}
+
+		// Queue packets to be sent.
+		for i := range queue {
+			if retries[i] != 0 {
+				continue // Packet currently queued for retransmission.
+			}
+			lenBuf[i], err = n.stack.HandleEth(queue[i][:])
+			if err != nil {
+				n.stackErrs.Add(1)
+				n.cfg.Logger.Debug("nic:stack", slog.Int("n", lenBuf[i]), slog.String("err", err.Error()))
+				lenBuf[i] = 0
+				continue
+			}
+			if lenBuf[i] == 0 {
+				break
+			}
+		}
+
+		// Datagrams queued by SendUDP are sent right away, without retries.
+		stallTx := true
+		if size := takeRawFrame(rawFrame[:]); size > 0 {
+			stallTx = false
+			if err := n.dev.SendEth(rawFrame[:size]); err != nil {
+				n.drops.Add(1)
+			} else {
+				n.tx.Add(1)
+			}
+		}
+
+		// Send queued packets.
+		for i := range queue {
+			size := lenBuf[i]
+			if size <= 0 {
+				continue
+			}
+			stallTx = false
+			err := n.dev.SendEth(queue[i][:size])
+			if err == nil {
+				n.tx.Add(1)
+				markSent(i)
+				continue
+			}
+			// Queue packet for retransmission.
+			retries[i]++
+			n.retransmits.Add(1)
+			if retries[i] > n.cfg.MaxRetries {
+				markSent(i)
+				n.drops.Add(1)
+				n.cfg.Logger.Debug("nic:dropped outgoing packet", slog.String("err", err.Error()))
+			}
+		}
+
+		if !stallRx || !stallTx {
+			idle = n.cfg.MinIdle
+			continue
+		}
+		// Avoid busy waiting when both Rx and Tx stall, back off while idle.
+		select {
+		case <-n.stop:
+			return
+		case <-time.After(idle):
+		}
+		idle = min(2*idle, n.cfg.MaxIdle)
+	}
+}
@@ -0,0 +1,93 @@
+package wifi
+
+import (
+	"errors"
+	"net/netip"
+	"sync"
+
+	"github.com/soypat/seqs/eth"
+	"github.com/soypat/seqs/stacks"
+)
+
+const rawTxQueueSize = 2
+
+var errTxQueueFull = errors.New("udp tx queue full")
+
+// rawTx holds frames built outside of the PortStack. The stack does not let
+// other packages open UDP ports, so fire-and-forget datagrams are framed here
+// and nicLoop sends them along with the stack's own packets.
+var rawTx struct {
+	mu     sync.Mutex
+	frames [rawTxQueueSize][mtu]byte
+	lens   [rawTxQueueSize]int
+	ipID   uint16
+}
+
+// SendUDP queues a single UDP datagram from the stack's address and srcPort to
+// dst. dstHW is the hardware address of the next hop, usually the router.
+func SendUDP(stack *stacks.PortStack, dstHW [6]byte, srcPort uint16, dst netip.AddrPort, payload []byte) error {
+	const headersLen = eth.SizeEthernetHeader + eth.SizeIPv4Header + eth.SizeUDPHeader
+	if !dst.Addr().Is4() {
+		return errors.New("udp: destination must be IPv4")
+	}
+	if headersLen+len(payload) > mtu {
+		return errors.New("udp: payload exceeds MTU")
+	}
+	rawTx.mu.Lock()
+	defer rawTx.mu.Unlock()
+	slot := -1
+	for i, n := range rawTx.lens {
+		if n == 0 {
+			slot = i
+			break
+		}
+	}
+	if slot < 0 {
+		return errTxQueueFull
+	}
+	rawTx.ipID++
+
+	var pkt stacks.UDPPacket
+	pkt.Eth = eth.EthernetHeader{
+		Destination:     dstHW,
+		Source:          stack.HardwareAddr6(),
+		SizeOrEtherType: uint16(eth.EtherTypeIPv4),
+	}
+	pkt.IP = eth.IPv4Header{
+		Source:        stack.Addr().As4(),
+		Destination:   dst.Addr().As4(),
+		VersionAndIHL: 5, // No IP options.
+		TotalLength:   eth.SizeIPv4Header + eth.SizeUDPHeader + uint16(len(payload)),
+		Protocol:      17, // UDP
+		TTL:           64,
+		ID:            rawTx.ipID,
+		Flags:         0x40 << 8, // Don't fragment.
+	}
+	pkt.IP.Checksum = pkt.IP.CalculateChecksum()
+	pkt.UDP = eth.UDPHeader{
+		SourcePort:      srcPort,
+		DestinationPort: dst.Port(),
+		Length:          eth.SizeUDPHeader + uint16(len(payload)),
+	}
+	pkt.UDP.Checksum = pkt.UDP.CalculateChecksumIPv4(&pkt.IP, payload)
+
+	frame := rawTx.frames[slot][:]
+	pkt.PutHeaders(frame)
+	rawTx.lens[slot] = headersLen + copy(frame[headersLen:], payload)
+	return nil
+}
+
+// takeRawFrame moves the next queued frame into dst and returns its length,
+// 0 if the queue is empty.
+func takeRawFrame(dst []byte) int {
+	rawTx.mu.Lock()
+	defer rawTx.mu.Unlock()
+	for i, n := range rawTx.lens {
+		if n > 0 {
+			copy(dst, rawTx.frames[i][:n])
+			rawTx.lens[i] = 0
+			return n
+		}
+	}
+	return 0
+}